@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 告警规则类型
+const (
+	alertThreshold = "threshold" // 文档在榜单中的点击数高于 (op=below 时低于) value
+	alertRank      = "rank"      // 文档从榜单前 top_n 中跌出，从未进入的文档不触发
+	alertIdle      = "idle"      // 命名空间超过 window 没有任何点击 (只看评估实例本地的点击)
+)
+
+// 告警状态
+const (
+	alertOK       = "ok" // 尚未触发
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+var (
+	ErrAlertNotFound = errors.New("alert rule not found")
+	ErrAlertInvalid  = errors.New("invalid alert rule")
+	ErrAlertLimit    = errors.New("alert rule limit reached")
+	ErrAlertDisabled = errors.New("alert rules are evaluated on another instance")
+)
+
+// AlertRule 为告警规则及其当前状态
+// 条件成立时进入 firing，不再成立时进入 resolved；距上次触发不足 cooldown 时不再触发
+type AlertRule struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Kind      string    `json:"kind"`
+	Board     string    `json:"board,omitempty"`
+	DocID     string    `json:"doc_id,omitempty"`
+	Op        string    `json:"op,omitempty"`
+	Value     int       `json:"value,omitempty"`
+	TopN      int       `json:"top_n,omitempty"`
+	Window    string    `json:"window,omitempty"`
+	Cooldown  string    `json:"cooldown,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"` // 进入当前状态的时间
+	LastFired time.Time `json:"last_fired,omitzero"`
+	Observed  int       `json:"observed"`         // 最近一次评估的值：点击数、名次或无点击的秒数
+	InTop     bool      `json:"in_top,omitempty"` // rank 规则：上次评估时文档在前 top_n 内
+
+	window   time.Duration
+	cooldown time.Duration
+}
+
+// parse 校验规则并解析时长
+func (r *AlertRule) parse() error {
+	var err error
+	if r.Cooldown != "" {
+		if r.cooldown, err = time.ParseDuration(r.Cooldown); err != nil || r.cooldown < 0 {
+			return fmt.Errorf("%w: bad cooldown", ErrAlertInvalid)
+		}
+	}
+	switch r.Kind {
+	case alertThreshold, alertRank:
+		if r.DocID == "" {
+			return fmt.Errorf("%w: doc_id is required", ErrAlertInvalid)
+		}
+		if r.Board == "" {
+			r.Board = "total"
+		}
+		if !slices.Contains(rankBoardNames, r.Board) {
+			return ErrUnknownBoard
+		}
+		if r.Kind == alertRank && r.TopN <= 0 {
+			return fmt.Errorf("%w: top_n must be positive", ErrAlertInvalid)
+		}
+		if r.Kind == alertThreshold && r.Op == "" {
+			r.Op = "above"
+		}
+		if r.Kind == alertThreshold && r.Op != "above" && r.Op != "below" {
+			return fmt.Errorf("%w: op must be above or below", ErrAlertInvalid)
+		}
+	case alertIdle:
+		if r.window, err = time.ParseDuration(r.Window); err != nil || r.window <= 0 {
+			return fmt.Errorf("%w: bad window", ErrAlertInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrAlertInvalid, r.Kind)
+	}
+	return nil
+}
+
+// check 评估规则，返回条件是否成立、观测值与说明
+func (r *AlertRule) check(s *Store, now time.Time) (bool, int, string, error) {
+	switch r.Kind {
+	case alertThreshold:
+		n, _, err := s.BoardStat(r.Board, r.DocID, 0)
+		if err != nil {
+			return false, 0, "", err
+		}
+		if r.Op == "below" {
+			return n < r.Value, n, fmt.Sprintf("%s clicks on %s: %d, below %d", r.Board, r.DocID, n, r.Value), nil
+		}
+		return n > r.Value, n, fmt.Sprintf("%s clicks on %s: %d, above %d", r.Board, r.DocID, n, r.Value), nil
+	case alertRank:
+		_, rank, err := s.BoardStat(r.Board, r.DocID, r.TopN)
+		if err != nil {
+			return false, 0, "", err
+		}
+		if rank == 0 {
+			return true, 0, fmt.Sprintf("%s is not in the top %d of %s", r.DocID, r.TopN, r.Board), nil
+		}
+		return false, rank, fmt.Sprintf("%s is #%d of %s", r.DocID, rank, r.Board), nil
+	case alertIdle:
+		idle := now.Sub(s.LastClick())
+		return idle >= r.window, int(idle.Seconds()), fmt.Sprintf("no clicks for %s", idle.Truncate(time.Second)), nil
+	}
+	return false, 0, "", nil
+}
+
+// alertsFile 为落盘格式
+type alertsFile struct {
+	Rules   []*AlertRule `json:"rules"`
+	History []AlertEvent `json:"history"`
+}
+
+// Alerts 周期评估命名空间的告警规则，状态变化记入历史并经 SSE 推送
+// 规则、状态与历史保存在命名空间目录；未开启评估的实例不评估也不接受规则变更，避免多实例重复告警
+type Alerts struct {
+	mu          sync.Mutex
+	ns          string
+	path        string
+	rules       []*AlertRule
+	history     []AlertEvent
+	dirty       bool
+	maxRules    int
+	historySize int
+	interval    time.Duration
+	enabled     bool
+
+	store *Store
+	sse   *SSEHub
+}
+
+// NewAlerts 创建并从 path 恢复命名空间 ns 的告警规则
+func NewAlerts(ns, path string, store *Store, sse *SSEHub, cfg Config) (*Alerts, error) {
+	a := &Alerts{
+		ns:          ns,
+		path:        path,
+		maxRules:    cfg.AlertMaxRules,
+		historySize: cfg.AlertHistory,
+		interval:    cfg.AlertEvalInterval,
+		enabled:     cfg.AlertEvaluator,
+		store:       store,
+		sse:         sse,
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f alertsFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	for _, r := range f.Rules {
+		if err := r.parse(); err != nil {
+			return nil, fmt.Errorf("alert rule %s: %w", r.ID, err)
+		}
+	}
+	a.rules, a.history = f.Rules, f.History
+	return a, nil
+}
+
+// Create 添加规则
+func (a *Alerts) Create(req CreateAlertReq) (AlertRule, error) {
+	now := time.Now()
+	r := &AlertRule{
+		ID:        randomID(8),
+		Name:      req.Name,
+		Kind:      req.Kind,
+		Board:     req.Board,
+		DocID:     req.DocID,
+		Op:        req.Op,
+		Value:     req.Value,
+		TopN:      req.TopN,
+		Window:    req.Window,
+		Cooldown:  req.Cooldown,
+		CreatedAt: now,
+		State:     alertOK,
+		Since:     now,
+	}
+	if !a.enabled {
+		return AlertRule{}, ErrAlertDisabled
+	}
+	if err := r.parse(); err != nil {
+		return AlertRule{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxRules > 0 && len(a.rules) >= a.maxRules {
+		return AlertRule{}, ErrAlertLimit
+	}
+	a.rules = append(a.rules, r)
+	a.dirty = true
+	return *r, nil
+}
+
+// List 返回全部规则及其状态
+func (a *Alerts) List() []AlertRule {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]AlertRule, len(a.rules))
+	for i, r := range a.rules {
+		out[i] = *r
+	}
+	return out
+}
+
+// Delete 删除规则
+func (a *Alerts) Delete(id string) error {
+	if !a.enabled {
+		return ErrAlertDisabled
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := slices.IndexFunc(a.rules, func(r *AlertRule) bool { return r.ID == id })
+	if i < 0 {
+		return ErrAlertNotFound
+	}
+	a.rules = slices.Delete(a.rules, i, i+1)
+	a.dirty = true
+	return nil
+}
+
+// History 返回最近的状态变化，新的在前
+func (a *Alerts) History(limit int) []AlertEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]AlertEvent, 0, min(limit, len(a.history)))
+	for i := len(a.history) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, a.history[i])
+	}
+	return out
+}
+
+// evaluate 评估全部规则，状态变化在解锁后推送
+func (a *Alerts) evaluate(now time.Time) {
+	var events []AlertEvent
+	a.mu.Lock()
+	for _, r := range a.rules {
+		cond, v, msg, err := r.check(a.store, now)
+		if err != nil {
+			continue
+		}
+		// 观测值每次评估都可能变化，只随其他变更一起落盘
+		r.Observed = v
+		if r.Kind == alertRank {
+			// 只在从榜内跌出时触发，已触发的规则在回到榜内前保持 firing
+			out := cond
+			cond = out && (r.InTop || r.State == alertFiring)
+			if r.InTop == out {
+				r.InTop = !out
+				a.dirty = true
+			}
+		}
+		switch {
+		case cond && r.State != alertFiring:
+			// 冷却期内条件再次成立不触发
+			if !r.LastFired.IsZero() && now.Sub(r.LastFired) < r.cooldown {
+				continue
+			}
+			r.State, r.Since, r.LastFired = alertFiring, now, now
+		case !cond && r.State == alertFiring:
+			r.State, r.Since = alertResolved, now
+		default:
+			continue
+		}
+		ev := AlertEvent{
+			ID:      randomID(8),
+			RuleID:  r.ID,
+			Name:    r.Name,
+			Kind:    r.Kind,
+			State:   r.State,
+			DocID:   r.DocID,
+			Value:   v,
+			Message: msg,
+			Time:    now,
+		}
+		events = append(events, ev)
+		a.history = append(a.history, ev)
+		if len(a.history) > a.historySize {
+			a.history = slices.Delete(a.history, 0, len(a.history)-a.historySize)
+		}
+		a.dirty = true
+	}
+	a.mu.Unlock()
+	for _, ev := range events {
+		a.sse.PublishAlert(ev)
+	}
+}
+
+// Start 启动周期评估与落盘，未开启评估时不启动
+func (a *Alerts) Start(stop <-chan struct{}) {
+	if !a.enabled {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				a.evaluate(now)
+				if err := a.saveIfDirty(); err != nil {
+					log.Printf("[%s] alerts save error: %v", a.ns, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// saveIfDirty 有变化时落盘
+func (a *Alerts) saveIfDirty() error {
+	a.mu.Lock()
+	if !a.dirty {
+		a.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(alertsFile{Rules: a.rules, History: a.history})
+	a.dirty = false
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.path, b)
+}
+
+// Close 保存最后的状态，包括最近的观测值
+func (a *Alerts) Close() error {
+	a.mu.Lock()
+	a.dirty = a.dirty || a.enabled && len(a.rules) > 0
+	a.mu.Unlock()
+	return a.saveIfDirty()
+}
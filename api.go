@@ -1,103 +1,388 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRouter 构建 HTTP 路由
-func SetupRouter(store *Store, sse *SSEHub, cfg Config) *gin.Engine {
-	r := gin.Default()
+// 原有路径作用于默认命名空间，/ns/:ns 下为相同接口作用于指定命名空间
+// 开启鉴权时先校验密钥 (401)，再按路由组要求的角色与命名空间授权 (403)
+func SetupRouter(nsm *Namespaces, auth *Auth, tokens *ClickTokens, limiter *RateLimiter, cfg Config) (*gin.Engine, error) {
+	r := gin.New()
+	r.Use(gin.LoggerWithConfig(gin.LoggerConfig{Formatter: redactLogFormatter}), gin.Recovery())
+	// 客户端 IP 用于限流与连接列表，只信任配置的代理转发的地址
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
 
-	// SSE
-	r.GET("/events", sse.Serve)
+	registerRoutes(r.Group("", auth.Authenticate(), nsm.UseDefault()), auth, tokens, limiter, cfg)
+	registerRoutes(r.Group("/ns/:ns", auth.Authenticate(), nsm.UseParam()), auth, tokens, limiter, cfg)
+	// 跳转链接：开启点击令牌时以令牌代替 API 密钥
+	goAuth := []gin.HandlerFunc{auth.Authenticate(), auth.Require(roleClicker)}
+	if tokens.Enabled() {
+		goAuth = nil
+	}
+	registerGo(r.Group("", nsm.UseDefault()).Group("", goAuth...), tokens, limiter)
+	registerGo(r.Group("/ns/:ns", nsm.UseParam()).Group("", goAuth...), tokens, limiter)
+
+	admin := r.Group("/admin", auth.Authenticate(), auth.Require(roleAdmin))
+	registerNamespaceAdmin(admin.Group("/namespaces"), nsm)
+	// 限流统计
+	admin.GET("/ratelimits", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"routes": limiter.Stats()})
+	})
+
+	return r, nil
+}
+
+// registerRoutes 注册命名空间内的接口，Store 与 SSE 取自请求上下文
+func registerRoutes(r *gin.RouterGroup, auth *Auth, tokens *ClickTokens, limiter *RateLimiter, cfg Config) {
+	read := r.Group("", auth.Require(roleReader))
+	click := r.Group("", auth.Require(roleClicker))
+	edit := r.Group("", auth.Require(roleEditor))
+	admin := r.Group("", auth.Require(roleAdmin))
+
+	// SSE：榜单事件带 stream_version，只用于衔接推送的差异，不能作为 /rank 的 since_version
+	read.GET("/events", func(c *gin.Context) {
+		nsOf(c).sse.Serve(c)
+	})
+	// WebSocket：事件同 SSE，另可上报点击
+	read.GET("/ws", NewWSServer(auth, tokens, limiter, cfg).Serve)
 
 	// 点击
-	r.POST("/click", func(c *gin.Context) {
+	click.POST("/click", limiter.Limit("click"), func(c *gin.Context) {
 		var req ClickReq
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "bad request"})
 			return
 		}
-		n, ok, err := store.Click(req.DocID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		c.JSON(submitClick(c, tokens, req, uncounted(c)))
+	})
+
+	// 签发点击令牌，并给出带令牌的跳转链接
+	read.GET("/docs/:id/click-token", func(c *gin.Context) {
+		store := storeOf(c)
+		if !tokens.Enabled() {
+			c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "click tokens disabled"})
 			return
 		}
-		if !ok {
+		id := c.Param("id")
+		// 开启自动登记时，符合 ID 规则的未知文档也可取得令牌，首次点击时登记
+		if !store.Clickable(id) {
 			c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "document not found"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"doc_id": req.DocID, "clicks": n})
+		ns := nsOf(c).Name
+		token, expAt := tokens.Issue(ns, id, time.Now())
+		c.JSON(http.StatusOK, ClickTokenResp{DocID: id, Token: token, ExpiresAt: expAt.Unix(), GoURL: goURL(ns, id, token)})
 	})
 
 	// 统一排行榜：同时返回总榜与最近榜
-	r.GET("/rank", func(c *gin.Context) {
-		limitStr := c.Query("limit")
-		limit := cfg.TopKDefault
-		if limitStr != "" {
-			if v, err := strconv.Atoi(limitStr); err == nil && v > 0 {
-				limit = v
-			}
+	// version 与 since_version 为榜单快照版本，与 SSE 的 stream_version 不是同一序列
+	// 结果只含本实例的点击；多实例部署时 SSE 推送的是各实例合并后的榜单，两者计数与名次可能不同
+	read.GET("/rank", func(c *gin.Context) {
+		store := storeOf(c)
+		q, err := parseRankQuery(c, cfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+			return
+		}
+		// 区间查询只返回一个榜单
+		if q.HasRange() {
+			c.JSON(http.StatusOK, store.RankRange(q))
+			return
+		}
+		boards, err := store.RankBoards([]string{"total", "recent"}, q)
+		if err != nil {
+			writeRankError(c, err)
+			return
 		}
-		total := store.TopK(limit)
-		recent := store.TopKRecent(limit)
 		c.JSON(http.StatusOK, gin.H{
-			"total":  RankResp{Rank: total},
-			"recent": RankResp{Rank: recent},
+			"total":  boards["total"],
+			"recent": boards["recent"],
 		})
 	})
 
 	// 兼容旧的总排行榜
-	r.GET("/rank/total", func(c *gin.Context) {
-		limitStr := c.Query("limit")
-		limit := cfg.TopKDefault
-		if limitStr != "" {
-			if v, err := strconv.Atoi(limitStr); err == nil && v > 0 {
-				limit = v
+	read.GET("/rank/total", func(c *gin.Context) {
+		store := storeOf(c)
+		serveBoard(c, store, cfg, "total")
+	})
+
+	// 兼容旧的近 10 分钟排行榜
+	read.GET("/rank/recent", func(c *gin.Context) {
+		store := storeOf(c)
+		serveBoard(c, store, cfg, "recent")
+	})
+
+	// 区间对比：from/to 为本期，prev_from/prev_to 为上期，缺省为紧邻本期之前的等长区间
+	read.GET("/rank/compare", func(c *gin.Context) {
+		store := storeOf(c)
+		var cur, prev TimeRange
+		for _, p := range []struct {
+			name string
+			dst  *int64
+		}{{"from", &cur.From}, {"to", &cur.To}, {"prev_from", &prev.From}, {"prev_to", &prev.To}} {
+			if s := c.Query(p.name); s != "" {
+				t, err := parseTimestamp(s)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "invalid " + p.name})
+					return
+				}
+				*p.dst = t.Unix()
 			}
 		}
-		top := store.TopK(limit)
-		c.JSON(http.StatusOK, RankResp{Rank: top})
+		if cur.From == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "from is required"})
+			return
+		}
+		if cur.To == 0 {
+			// 包含当前这一秒
+			cur.To = time.Now().Unix() + 1
+		}
+		if prev.From == 0 && prev.To == 0 {
+			prev = TimeRange{From: cur.From - (cur.To - cur.From), To: cur.From}
+		}
+		if cur.From >= cur.To || prev.From >= prev.To {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "from must be before to"})
+			return
+		}
+		limit := 10
+		if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+			limit = v
+		}
+		c.JSON(http.StatusOK, store.Compare(cur, prev, limit, wantExpand(c)))
 	})
 
-	// 兼容旧的近 10 分钟排行榜
-	r.GET("/rank/recent", func(c *gin.Context) {
-		limitStr := c.Query("limit")
-		limit := cfg.TopKDefault
-		if limitStr != "" {
-			if v, err := strconv.Atoi(limitStr); err == nil && v > 0 {
-				limit = v
+	// 导出任意榜单：开始时复制榜单顺序，再分页关联文档字段并流式写出
+	read.GET("/rank/export", func(c *gin.Context) {
+		store := storeOf(c)
+		format, ok := detectFormat(c.Query("format"), "")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "unsupported format"})
+			return
+		}
+		board := c.DefaultQuery("board", "total")
+		limit := 0
+		if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+			limit = v
+		}
+		const pageSize = 500
+		ranked, ok := store.RankExport(board, limit)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "unknown board"})
+			return
+		}
+		c.Header("Content-Type", exportContentType(format))
+		c.Header("Content-Disposition", "attachment; filename=rank-"+board+"."+format)
+		c.Status(http.StatusOK)
+		enc, err := newRowEncoder(c.Writer, format, []string{"rank", "doc_id", "title", "url", "clicks"})
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		for start := 0; start < len(ranked); start += pageSize {
+			page := ranked[start:min(start+pageSize, len(ranked))]
+			for _, it := range store.RankExportRows(page, start) {
+				rec := []string{strconv.Itoa(it.Rank), it.DocID, it.Title, it.URL, strconv.Itoa(it.Clicks)}
+				if err := enc.Write(it, rec); err != nil {
+					_ = c.Error(err)
+					return
+				}
+			}
+			if err := enc.Flush(); err != nil {
+				_ = c.Error(err)
+				return
 			}
+			c.Writer.Flush()
+		}
+		if err := enc.Close(); err != nil {
+			_ = c.Error(err)
 		}
-		top := store.TopKRecent(limit)
-		c.JSON(http.StatusOK, RankResp{Rank: top})
 	})
 
 	// 文档列表
-	r.GET("/docs", func(c *gin.Context) {
-		c.JSON(http.StatusOK, DocsResp{Documents: store.ListDocs()})
+	read.GET("/docs", func(c *gin.Context) {
+		store := storeOf(c)
+		docs := store.ListDocs()
+		resp := DocsResp{Documents: docs}
+		if tokens.Enabled() {
+			ns := nsOf(c).Name
+			now := time.Now()
+			resp.ClickTokens = make(map[string]string, len(docs))
+			for _, d := range docs {
+				resp.ClickTokens[d.ID], _ = tokens.Issue(ns, d.ID, now)
+			}
+		}
+		c.JSON(http.StatusOK, resp)
 	})
 
 	// 新增或修改文档
-	r.POST("/docs", func(c *gin.Context) {
+	edit.POST("/docs", func(c *gin.Context) {
+		store := storeOf(c)
 		var req UpsertDocReq
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "bad request"})
 			return
 		}
-		doc := Doc{ID: req.ID, Title: req.Title, URL: req.URL}
+		doc := Doc{ID: req.ID, Title: req.Title, URL: req.URL, Meta: req.Meta}
 		if err := store.AddOrUpdateDoc(doc); err != nil {
+			if errors.Is(err, ErrDocLimit) {
+				c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, doc)
 	})
 
+	// 批量导入文档：支持 JSON 数组、NDJSON 与 CSV，dry_run=true 时仅校验
+	edit.POST("/docs/bulk", func(c *gin.Context) {
+		store := storeOf(c)
+		format, ok := detectFormat(c.Query("format"), c.ContentType())
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "unsupported format"})
+			return
+		}
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, cfg.BulkMaxBytes)
+		rows, rowErrs, err := parseBulkDocs(format, body, cfg.BulkMaxRows)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"code": 413, "message": "request body too large"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+			return
+		}
+		dryRun := c.Query("dry_run") == "true"
+		docs := make([]Doc, 0, len(rows))
+		for _, row := range rows {
+			docs = append(docs, row.doc)
+		}
+		added, updated, err := store.BulkUpsertDocs(docs, dryRun)
+		if errors.Is(err, ErrDocLimit) {
+			c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+			return
+		}
+		if rowErrs == nil {
+			rowErrs = []BulkRowError{}
+		}
+		c.JSON(http.StatusOK, BulkResp{
+			DryRun:  dryRun,
+			Total:   len(rows) + len(rowErrs),
+			Added:   added,
+			Updated: updated,
+			Failed:  len(rowErrs),
+			Errors:  rowErrs,
+		})
+	})
+
+	// 导出文档及当前计数
+	read.GET("/docs/export", func(c *gin.Context) {
+		store := storeOf(c)
+		format, ok := detectFormat(c.Query("format"), "")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "unsupported format"})
+			return
+		}
+		items := store.ExportDocs()
+		c.Header("Content-Type", exportContentType(format))
+		c.Header("Content-Disposition", "attachment; filename=docs."+format)
+		c.Status(http.StatusOK)
+		if err := writeDocsExport(c.Writer, format, items); err != nil {
+			_ = c.Error(err)
+		}
+	})
+
+	// 文档点击时间序列：from、to 为秒级时间戳或 RFC3339，step 如 1m、1h、24h
+	read.GET("/docs/:id/timeseries", func(c *gin.Context) {
+		store := storeOf(c)
+		now := time.Now()
+		to, from := now, now.Add(-24*time.Hour)
+		step := time.Hour
+		if s := c.Query("to"); s != "" {
+			t, err := parseTimestamp(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "invalid to"})
+				return
+			}
+			to, from = t, t.Add(-24*time.Hour)
+		}
+		if s := c.Query("from"); s != "" {
+			t, err := parseTimestamp(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "invalid from"})
+				return
+			}
+			from = t
+		}
+		if s := c.Query("step"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "invalid step"})
+				return
+			}
+			step = d
+		}
+		if !from.Before(to) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "from must be before to"})
+			return
+		}
+		id := c.Param("id")
+		stepSec := int64(step / time.Second)
+		points, res, ok, err := store.DocTimeSeries(id, from.Unix(), to.Unix(), stepSec)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "document not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"doc_id":     id,
+			"from":       from.Unix(),
+			"to":         to.Unix(),
+			"step":       stepSec,
+			"resolution": res,
+			"points":     points,
+		})
+	})
+
+	// 文档点击来源分布，dim 可选 referrer、campaign、app、locale
+	read.GET("/docs/:id/sources", func(c *gin.Context) {
+		store := storeOf(c)
+		dim := c.Query("dim")
+		if dim != "" && !slices.Contains(sourceDims, dim) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "invalid dim"})
+			return
+		}
+		id := c.Param("id")
+		sources, ok := store.DocSources(id, dim)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "document not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"doc_id": id, "sources": sources})
+	})
+
 	// 删除文档
-	r.DELETE("/docs/:id", func(c *gin.Context) {
+	edit.DELETE("/docs/:id", func(c *gin.Context) {
+		store := storeOf(c)
 		id := c.Param("id")
 		if id == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "bad request"})
@@ -110,5 +395,449 @@ func SetupRouter(store *Store, sse *SSEHub, cfg Config) *gin.Engine {
 		c.JSON(http.StatusOK, gin.H{"id": id})
 	})
 
-	return r
+	// 被过滤器隔离与标记的点击
+	admin.GET("/quarantine", func(c *gin.Context) {
+		c.JSON(http.StatusOK, storeOf(c).Quarantined())
+	})
+
+	// 审核隔离的点击：approve 按原始时间计入，discard 丢弃
+	review := func(apply func(store *Store, id string) error) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			store := storeOf(c)
+			var req QuarantineReviewReq
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "bad request"})
+				return
+			}
+			items := make([]QuarantineReviewItem, 0, len(req.IDs))
+			for _, id := range req.IDs {
+				item := QuarantineReviewItem{ID: id, OK: true}
+				if err := apply(store, id); err != nil {
+					item.OK, item.Error = false, err.Error()
+				}
+				items = append(items, item)
+			}
+			c.JSON(http.StatusOK, gin.H{"results": items})
+		}
+	}
+	admin.POST("/quarantine/approve", review((*Store).ApproveQuarantined))
+	admin.POST("/quarantine/discard", review((*Store).DiscardQuarantined))
+
+	registerWebhooks(admin.Group("/webhooks"))
+	registerAlerts(read.Group("/alerts"), admin.Group("/alerts"))
+
+	// 当前 SSE 与 WebSocket 连接及其积压、丢弃情况
+	admin.GET("/clients", func(c *gin.Context) {
+		clients, stats := nsOf(c).sse.Clients()
+		c.JSON(http.StatusOK, gin.H{"clients": clients, "stats": stats})
+	})
+}
+
+// registerGo 注册跳转链接：记录点击后重定向到文档 URL
+func registerGo(r *gin.RouterGroup, tokens *ClickTokens, limiter *RateLimiter) {
+	r.GET("/go/:id", limiter.Limit("go"), func(c *gin.Context) {
+		store := storeOf(c)
+		id := c.Param("id")
+		target, ok, err := store.GoURL(id)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "document not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": err.Error()})
+			return
+		}
+		if tokens.Enabled() {
+			if err := tokens.Verify(nsOf(c).Name, id, c.Query("t"), time.Now()); err != nil {
+				writeClickTokenError(c, err)
+				return
+			}
+		}
+		if uncounted(c) {
+			c.Redirect(http.StatusFound, target)
+			return
+		}
+		_, ok, err = store.Click(ClickEvent{
+			DocID: id,
+			Source: ClickSource{
+				Referrer: c.GetHeader("Referer"),
+				Campaign: c.Query("campaign"),
+				App:      c.Query("app"),
+				Locale:   primaryLanguage(c.GetHeader("Accept-Language")),
+			},
+			ClientIP:  c.ClientIP(),
+			Visitor:   clientKey(c, limitByVisitor),
+			UserAgent: c.Request.UserAgent(),
+		})
+		if !clickOK(c, ok, err) {
+			return
+		}
+		c.Redirect(http.StatusFound, target)
+	})
+}
+
+// submitClick 校验点击令牌并计入点击，返回状态码与响应体；HTTP 与 WebSocket 点击共用
+// uncount 为真时 (超限但按规则不计数) 只返回当前计数，不暴露限流
+func submitClick(c *gin.Context, tokens *ClickTokens, req ClickReq, uncount bool) (int, gin.H) {
+	store := storeOf(c)
+	// 未在请求体中给出时，referrer 与 locale 取自请求头
+	if req.Referrer == "" {
+		req.Referrer = c.GetHeader("Referer")
+	}
+	if req.Locale == "" {
+		req.Locale = primaryLanguage(c.GetHeader("Accept-Language"))
+	}
+	// 开启点击令牌时校验令牌，令牌可放在请求体或 X-Click-Token 头
+	if tokens.Enabled() {
+		token := req.Token
+		if token == "" {
+			token = c.GetHeader("X-Click-Token")
+		}
+		if err := tokens.Verify(nsOf(c).Name, req.DocID, token, time.Now()); err != nil {
+			return clickTokenError(err)
+		}
+	}
+	if uncount {
+		n, ok := store.ClickCount(req.DocID)
+		if status, body := clickError(ok, nil); status != 0 {
+			return status, body
+		}
+		return http.StatusOK, gin.H{"doc_id": req.DocID, "clicks": n}
+	}
+	n, ok, err := store.Click(ClickEvent{
+		DocID:     req.DocID,
+		Source:    req.ClickSource,
+		Title:     req.Title,
+		URL:       req.URL,
+		ClientIP:  c.ClientIP(),
+		Visitor:   clientKey(c, limitByVisitor),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if status, body := clickError(ok, err); status != 0 {
+		return status, body
+	}
+	return http.StatusOK, gin.H{"doc_id": req.DocID, "clicks": n}
+}
+
+// clickError 将点击结果转换为错误状态码与响应体，成功时状态码为 0
+func clickError(ok bool, err error) (int, gin.H) {
+	if errors.Is(err, ErrAutoRegisterQuota) {
+		return http.StatusTooManyRequests, gin.H{"code": 429, "message": err.Error()}
+	}
+	if errors.Is(err, ErrNamespaceClosed) {
+		return http.StatusNotFound, gin.H{"code": 404, "message": err.Error()}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()}
+	}
+	if !ok {
+		return http.StatusNotFound, gin.H{"code": 404, "message": "document not found"}
+	}
+	return 0, nil
+}
+
+// clickOK 将点击结果转换为错误响应，成功时返回 true
+func clickOK(c *gin.Context, ok bool, err error) bool {
+	if status, body := clickError(ok, err); status != 0 {
+		c.JSON(status, body)
+		return false
+	}
+	return true
+}
+
+// clickTokenError 令牌缺失、无效或过期为 403，重放为 409
+func clickTokenError(err error) (int, gin.H) {
+	status := http.StatusForbidden
+	if errors.Is(err, ErrClickTokenUsed) {
+		status = http.StatusConflict
+	}
+	return status, gin.H{"code": status, "message": err.Error()}
+}
+
+// writeClickTokenError 写出点击令牌错误
+func writeClickTokenError(c *gin.Context, err error) {
+	c.JSON(clickTokenError(err))
+}
+
+// goURL 返回带令牌的跳转链接
+func goURL(ns, id, token string) string {
+	prefix := ""
+	if ns != defaultNamespace {
+		prefix = "/ns/" + ns
+	}
+	return prefix + "/go/" + url.PathEscape(id) + "?t=" + url.QueryEscape(token)
+}
+
+// registerWebhooks 注册命名空间内的回调管理接口
+func registerWebhooks(r *gin.RouterGroup) {
+	writeErr := func(c *gin.Context, err error) {
+		switch {
+		case errors.Is(err, ErrWebhookInvalid), errors.Is(err, ErrWebhookBlocked), errors.Is(err, ErrUnknownBoard):
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		case errors.Is(err, ErrWebhookNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": err.Error()})
+		case errors.Is(err, ErrWebhookLimit):
+			c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		}
+	}
+
+	r.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"webhooks": nsOf(c).hooks.List()})
+	})
+
+	// 返回中包含签名密钥，之后不再返回
+	r.POST("", func(c *gin.Context) {
+		var req CreateWebhookReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "bad request"})
+			return
+		}
+		h, err := nsOf(c).hooks.Create(req)
+		if err != nil {
+			writeErr(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, h)
+	})
+
+	r.DELETE("/:id", func(c *gin.Context) {
+		if err := nsOf(c).hooks.Delete(c.Param("id")); err != nil {
+			writeErr(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+	})
+
+	// 发送 ping 事件
+	r.POST("/:id/test", func(c *gin.Context) {
+		id, err := nsOf(c).hooks.Test(c.Param("id"))
+		if err != nil {
+			writeErr(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"delivery_id": id})
+	})
+
+	// 待投递事件与最近的投递记录
+	r.GET("/:id/deliveries", func(c *gin.Context) {
+		limit := 100
+		if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+			limit = v
+		}
+		pending, entries, err := nsOf(c).hooks.Deliveries(c.Param("id"), limit)
+		if err != nil {
+			writeErr(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"pending": pending, "log": entries})
+	})
+}
+
+// registerAlerts 注册告警规则接口：查看规则状态与历史需 reader，增删规则需 admin
+func registerAlerts(read, admin *gin.RouterGroup) {
+	writeErr := func(c *gin.Context, err error) {
+		switch {
+		case errors.Is(err, ErrAlertInvalid), errors.Is(err, ErrUnknownBoard):
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		case errors.Is(err, ErrAlertNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": err.Error()})
+		case errors.Is(err, ErrAlertLimit):
+			c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": err.Error()})
+		case errors.Is(err, ErrAlertDisabled):
+			c.JSON(http.StatusConflict, gin.H{"code": 409, "message": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		}
+	}
+
+	read.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"alerts": nsOf(c).alerts.List()})
+	})
+
+	// 最近的状态变化，新的在前
+	read.GET("/history", func(c *gin.Context) {
+		limit := 100
+		if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+			limit = v
+		}
+		c.JSON(http.StatusOK, gin.H{"events": nsOf(c).alerts.History(limit)})
+	})
+
+	admin.POST("", func(c *gin.Context) {
+		var req CreateAlertReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "bad request"})
+			return
+		}
+		rule, err := nsOf(c).alerts.Create(req)
+		if err != nil {
+			writeErr(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, rule)
+	})
+
+	admin.DELETE("/:id", func(c *gin.Context) {
+		if err := nsOf(c).alerts.Delete(c.Param("id")); err != nil {
+			writeErr(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+	})
+}
+
+// registerNamespaceAdmin 注册命名空间管理接口
+func registerNamespaceAdmin(r *gin.RouterGroup, nsm *Namespaces) {
+	r.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"namespaces": nsm.List()})
+	})
+
+	r.POST("", func(c *gin.Context) {
+		var req CreateNamespaceReq
+		if err := c.ShouldBindJSON(&req); err != nil || req.MaxDocs < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "bad request"})
+			return
+		}
+		limits := NamespaceLimits{MaxDocs: req.MaxDocs}
+		switch err := nsm.Create(req.Name, limits); {
+		case errors.Is(err, ErrNamespaceInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		case errors.Is(err, ErrNamespaceExists):
+			c.JSON(http.StatusConflict, gin.H{"code": 409, "message": err.Error()})
+		case errors.Is(err, ErrNamespaceLimit):
+			c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+		default:
+			c.JSON(http.StatusCreated, NamespaceInfo{Name: req.Name, Limits: limits})
+		}
+	})
+
+	r.DELETE("/:ns", func(c *gin.Context) {
+		name := c.Param("ns")
+		if name == defaultNamespace {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "default namespace cannot be deleted"})
+			return
+		}
+		if err := nsm.Delete(name); err != nil {
+			if errors.Is(err, ErrNamespaceNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"name": name})
+	})
+}
+
+// wantExpand 判断请求是否要求在榜单条目中附带文档字段
+func wantExpand(c *gin.Context) bool {
+	switch c.Query("expand") {
+	case "doc", "true", "1":
+		return true
+	}
+	return false
+}
+
+// parseRankQuery 解析榜单查询参数：limit、expand、since (如 1m、1h)、since_version、at 与 from/to
+func parseRankQuery(c *gin.Context, cfg Config) (RankQuery, error) {
+	q := RankQuery{K: cfg.TopKDefault, Expand: wantExpand(c)}
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		q.K = v
+	}
+	if s := c.Query("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return q, errors.New("invalid since")
+		}
+		q.Since = d
+	}
+	if s := c.Query("at"); s != "" {
+		t, err := parseTimestamp(s)
+		if err != nil {
+			return q, errors.New("invalid at")
+		}
+		q.At = t
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if s := c.Query(p.name); s != "" {
+			t, err := parseTimestamp(s)
+			if err != nil {
+				return q, errors.New("invalid " + p.name)
+			}
+			*p.dst = t
+		}
+	}
+	if q.HasRange() && q.From.IsZero() {
+		return q, errors.New("from is required")
+	}
+	if q.HasRange() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, errors.New("from must be before to")
+	}
+	if s := c.Query("source"); s != "" {
+		dim, value, ok := strings.Cut(s, ":")
+		if !ok || value == "" || !slices.Contains(sourceDims, dim) {
+			return q, errors.New("invalid source, expected <dimension>:<value>")
+		}
+		if q.HasRange() || !q.At.IsZero() {
+			return q, errors.New("source cannot be combined with at or from/to")
+		}
+		q.SourceDim, q.SourceValue = dim, value
+	}
+	if s := c.Query("since_version"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || v == 0 {
+			return q, errors.New("invalid since_version")
+		}
+		q.SinceVersion = v
+	}
+	return q, nil
+}
+
+// parseTimestamp 解析秒级 Unix 时间戳或 RFC3339 时间
+func parseTimestamp(s string) (time.Time, error) {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(v, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// serveBoard 返回单个榜单
+func serveBoard(c *gin.Context, store *Store, cfg Config, board string) {
+	q, err := parseRankQuery(c, cfg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	boards, err := store.RankBoards([]string{board}, q)
+	if err != nil {
+		writeRankError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, boards[board])
+}
+
+// writeRankError 将榜单查询错误映射为 HTTP 响应
+func writeRankError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrSourceBoard):
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+	case errors.Is(err, ErrUnknownBoard), errors.Is(err, ErrVersionNotFound), errors.Is(err, ErrNoArchive):
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+	}
+}
+
+// primaryLanguage 取 Accept-Language 中的第一个语言标签
+func primaryLanguage(h string) string {
+	tag, _, _ := strings.Cut(h, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	return strings.TrimSpace(tag)
 }
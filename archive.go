@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// archiveSnapshot 是持久化的榜单快照，只保存各榜单前若干项
+type archiveSnapshot struct {
+	At     int64                 `json:"at"` // 秒级时间戳 (Unix)
+	Boards map[string][]RankItem `json:"boards"`
+}
+
+// RankArchive 以 JSONL 追加保存历史榜单快照，并定期压缩
+// 近期快照保留原始频率，较早的快照每小时只保留一个，超过保留期的丢弃
+type RankArchive struct {
+	mu    sync.Mutex
+	path  string
+	f     *os.File
+	snaps []archiveSnapshot // 按时间升序
+
+	retention      time.Duration
+	fullResolution time.Duration
+}
+
+// NewRankArchive 打开或创建快照文件并载入已有快照
+func NewRankArchive(path string, retention, fullResolution time.Duration) (*RankArchive, error) {
+	a := &RankArchive{path: path, retention: retention, fullResolution: fullResolution}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	a.f = f
+	return a, nil
+}
+
+func (a *RankArchive) load() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var snap archiveSnapshot
+			// 损坏的行直接跳过
+			if json.Unmarshal(line, &snap) == nil && snap.At > 0 {
+				a.snaps = append(a.snaps, snap)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+	}
+	sort.SliceStable(a.snaps, func(i, j int) bool {
+		return a.snaps[i].At < a.snaps[j].At
+	})
+	return nil
+}
+
+// Append 追加一个快照并落盘
+func (a *RankArchive) Append(snap archiveSnapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := a.f.Sync(); err != nil {
+		return err
+	}
+	a.snaps = append(a.snaps, snap)
+	return nil
+}
+
+// Closest 返回与 at 时间最接近的快照
+func (a *RankArchive) Closest(at int64) (archiveSnapshot, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := len(a.snaps)
+	if n == 0 {
+		return archiveSnapshot{}, false
+	}
+	i := sort.Search(n, func(i int) bool { return a.snaps[i].At >= at })
+	switch {
+	case i == 0:
+		return a.snaps[0], true
+	case i == n:
+		return a.snaps[n-1], true
+	}
+	if at-a.snaps[i-1].At <= a.snaps[i].At-at {
+		return a.snaps[i-1], true
+	}
+	return a.snaps[i], true
+}
+
+// Compact 丢弃过期快照并稀疏化较早的快照，有变化时重写文件
+func (a *RankArchive) Compact(now time.Time) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	expire := now.Add(-a.retention).Unix()
+	thin := now.Add(-a.fullResolution).Unix()
+	kept := make([]archiveSnapshot, 0, len(a.snaps))
+	lastHour := int64(-1)
+	for _, snap := range a.snaps {
+		if snap.At < expire {
+			continue
+		}
+		if snap.At < thin {
+			// 每小时只保留第一个
+			hour := snap.At / 3600
+			if hour == lastHour {
+				continue
+			}
+			lastHour = hour
+		}
+		kept = append(kept, snap)
+	}
+	dropped := len(a.snaps) - len(kept)
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	// 写临时文件后替换
+	tmp := a.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, snap := range kept {
+		if err := enc.Encode(snap); err != nil {
+			_ = f.Close()
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := a.f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		return 0, err
+	}
+	nf, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	a.f = nf
+	a.snaps = kept
+	return dropped, nil
+}
+
+// Len 返回快照数量
+func (a *RankArchive) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.snaps)
+}
+
+// Close 关闭快照文件
+func (a *RankArchive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 角色，按权限从低到高，高权限包含低权限
+const (
+	roleReader  = "reader"  // 读取榜单、文档与事件流
+	roleClicker = "clicker" // 上报点击
+	roleEditor  = "editor"  // 管理文档
+	roleAdmin   = "admin"   // 管理命名空间
+)
+
+var roleLevel = map[string]int{roleReader: 1, roleClicker: 2, roleEditor: 3, roleAdmin: 4}
+
+// APIKey 为密钥文件中的一项；key 与 key_sha256 二选一，后者避免明文落盘
+// namespaces 为空时可访问全部命名空间，expires_at 便于轮换时让旧密钥自然失效
+type APIKey struct {
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	KeySHA256  string     `json:"key_sha256,omitempty"`
+	Role       string     `json:"role"`
+	Namespaces []string   `json:"namespaces,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// allows 判断密钥能否访问命名空间
+func (k APIKey) allows(ns string) bool {
+	return len(k.Namespaces) == 0 || slices.Contains(k.Namespaces, ns)
+}
+
+// keysFile 为密钥文件格式
+type keysFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// Auth 从配置文件加载 API 密钥，文件变更后自动重新加载，无需重启
+// 未配置密钥文件时不做鉴权
+type Auth struct {
+	mu      sync.RWMutex
+	path    string
+	keys    map[string]APIKey // 以密钥 SHA-256 索引
+	modTime time.Time
+}
+
+// NewAuth 创建鉴权并首次加载密钥文件
+func NewAuth(path string) (*Auth, error) {
+	a := &Auth{path: path, keys: make(map[string]APIKey)}
+	if path == "" {
+		return a, nil
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Enabled 判断是否开启鉴权
+func (a *Auth) Enabled() bool {
+	return a.path != ""
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Reload 重新读取密钥文件；格式错误时保留原有密钥
+func (a *Auth) Reload() error {
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	var f keysFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	keys := make(map[string]APIKey, len(f.Keys))
+	for _, k := range f.Keys {
+		if _, ok := roleLevel[k.Role]; !ok {
+			return errors.New("api key " + k.Name + ": unknown role " + k.Role)
+		}
+		h := strings.ToLower(k.KeySHA256)
+		if k.Key != "" {
+			h = hashKey(k.Key)
+		}
+		if h == "" {
+			return errors.New("api key " + k.Name + ": missing key")
+		}
+		k.Key = ""
+		keys[h] = k
+	}
+	a.mu.Lock()
+	a.keys = keys
+	a.modTime = fi.ModTime()
+	a.mu.Unlock()
+	return nil
+}
+
+// StartReloader 周期检查密钥文件修改时间，变更后重新加载
+func (a *Auth) StartReloader(interval time.Duration, stop <-chan struct{}) {
+	if !a.Enabled() || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fi, err := os.Stat(a.path)
+				if err != nil {
+					log.Printf("api keys stat error: %v", err)
+					continue
+				}
+				a.mu.RLock()
+				changed := !fi.ModTime().Equal(a.modTime)
+				a.mu.RUnlock()
+				if !changed {
+					continue
+				}
+				if err := a.Reload(); err != nil {
+					log.Printf("api keys reload error: %v", err)
+				} else {
+					log.Printf("api keys reloaded")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// lookup 按明文密钥查找，已过期视为不存在
+func (a *Auth) lookup(key string) (APIKey, bool) {
+	a.mu.RLock()
+	k, ok := a.keys[hashKey(key)]
+	a.mu.RUnlock()
+	if !ok || (k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)) {
+		return APIKey{}, false
+	}
+	return k, true
+}
+
+// requestKey 依次从 Authorization: Bearer、X-API-Key 与 api_key 查询参数取密钥
+// 查询参数用于无法设置请求头的 EventSource
+func requestKey(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if h := c.GetHeader("X-API-Key"); h != "" {
+		return h
+	}
+	return c.Query("api_key")
+}
+
+// apiKeyParam 匹配查询串中的 api_key 参数
+var apiKeyParam = regexp.MustCompile(`(^|[?&])api_key=[^&]*`)
+
+// redactLogFormatter 与 gin 默认的访问日志格式相同，但隐去查询串中的 api_key，避免密钥写入日志
+func redactLogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	path := apiKeyParam.ReplaceAllString(param.Path, "${1}api_key=REDACTED")
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		path,
+		param.ErrorMessage,
+	)
+}
+
+// Authenticate 校验密钥，缺失或无效时返回 401
+func (a *Auth) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+		key := requestKey(c)
+		if key == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 401, "message": "missing api key"})
+			return
+		}
+		k, ok := a.lookup(key)
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 401, "message": "invalid api key"})
+			return
+		}
+		c.Set("apikey", k)
+		c.Next()
+	}
+}
+
+// hasRole 判断请求的密钥是否至少具有 role 角色，未开启鉴权时总是成立
+func (a *Auth) hasRole(c *gin.Context, role string) bool {
+	if !a.Enabled() {
+		return true
+	}
+	k := c.MustGet("apikey").(APIKey)
+	return roleLevel[k.Role] >= roleLevel[role]
+}
+
+// Require 要求密钥至少具有 role 角色，且可访问当前命名空间，否则返回 403
+// 上下文中没有命名空间的路由 (如命名空间管理) 只允许不限命名空间的密钥
+func (a *Auth) Require(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+		if !a.hasRole(c, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": 403, "message": "requires role " + role})
+			return
+		}
+		k := c.MustGet("apikey").(APIKey)
+		allowed := len(k.Namespaces) == 0
+		if v, ok := c.Get("ns"); ok {
+			allowed = k.allows(v.(*Namespace).Name)
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": 403, "message": "namespace not permitted"})
+			return
+		}
+		c.Next()
+	}
+}
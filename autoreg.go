@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// metaAuto 为自动登记文档的元数据标记
+const metaAuto = "auto"
+
+var ErrAutoRegisterQuota = errors.New("auto-registration quota exceeded")
+
+// errAutoRegisterRejected 表示未知 ID 未通过自动登记校验，对外仍按文档不存在处理
+var errAutoRegisterRejected = errors.New("auto-registration rejected")
+
+// AutoRegister 决定未知 ID 的点击能否自动登记为占位文档
+// 支持 ID 正则、URL 域名白名单、文档总数上限与每分钟登记上限；由 Store 的锁保护
+type AutoRegister struct {
+	pattern   *regexp.Regexp
+	hosts     []string
+	maxDocs   int
+	perMinute int
+
+	count  int   // 当前自动登记文档数
+	minute int64 // 当前计数的分钟
+	used   int   // 当前分钟已登记数
+}
+
+// NewAutoRegister 按配置创建自动登记策略，未开启时返回 nil
+func NewAutoRegister(cfg Config) (*AutoRegister, error) {
+	if !cfg.AutoRegister {
+		return nil, nil
+	}
+	a := &AutoRegister{
+		maxDocs:   cfg.AutoRegisterMaxDocs,
+		perMinute: cfg.AutoRegisterPerMinute,
+	}
+	if cfg.AutoRegisterIDPattern != "" {
+		re, err := regexp.Compile(cfg.AutoRegisterIDPattern)
+		if err != nil {
+			return nil, err
+		}
+		a.pattern = re
+	}
+	for _, h := range cfg.AutoRegisterHosts {
+		a.hosts = append(a.hosts, strings.ToLower(h))
+	}
+	return a, nil
+}
+
+// hostAllowed 判断链接域名是否为白名单域名或其子域名
+func (a *AutoRegister) hostAllowed(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range a.hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// idAllowed 判断 ID 是否符合自动登记的 ID 规则
+func (a *AutoRegister) idAllowed(id string) bool {
+	return a.pattern == nil || a.pattern.MatchString(id)
+}
+
+// redirectAllowed 判断占位文档的链接能否用于跳转：链接来自客户端，只有配置了域名白名单且在其中时允许
+func (a *AutoRegister) redirectAllowed(raw string) bool {
+	return len(a.hosts) > 0 && a.hostAllowed(raw)
+}
+
+// Check 校验点击能否触发自动登记，不消耗配额
+func (a *AutoRegister) Check(ev ClickEvent, nowSec int64) error {
+	if !a.idAllowed(ev.DocID) {
+		return errAutoRegisterRejected
+	}
+	if len(a.hosts) > 0 && !a.hostAllowed(ev.URL) {
+		return errAutoRegisterRejected
+	}
+	if a.maxDocs > 0 && a.count >= a.maxDocs {
+		return ErrAutoRegisterQuota
+	}
+	if a.perMinute > 0 && nowSec/60 == a.minute && a.used >= a.perMinute {
+		return ErrAutoRegisterQuota
+	}
+	return nil
+}
+
+// Commit 记录一次成功的自动登记
+func (a *AutoRegister) Commit(nowSec int64) {
+	if m := nowSec / 60; m != a.minute {
+		a.minute, a.used = m, 0
+	}
+	a.used++
+	a.count++
+}
+
+// placeholder 构造占位文档
+func (a *AutoRegister) placeholder(ev ClickEvent) Doc {
+	return Doc{ID: ev.DocID, Title: ev.Title, URL: ev.URL, Meta: map[string]string{metaAuto: "true"}}
+}
+
+// isAutoDoc 判断文档是否为自动登记的占位文档
+func isAutoDoc(d Doc) bool {
+	return d.Meta[metaAuto] == "true"
+}
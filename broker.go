@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 经 Broker 分发的事件类型
+const (
+	brokerBoard = "board" // 完整榜单，各实例的 SSEHub 据此计算差异
+	brokerDoc   = "doc"   // 文档变更
+	brokerAlert = "alert" // 告警状态变化，只由开启 AlertEvaluator 的实例发布
+	brokerHello = "hello" // 实例启动，其他实例重发各自的榜单
+	brokerLeave = "leave" // 实例停止，其他实例移除其榜单
+)
+
+// BrokerEvent 为 SSEHub 之间分发的事件
+type BrokerEvent struct {
+	Origin string      `json:"origin"` // 发布实例的 InstanceID
+	Type   string      `json:"type"`
+	Board  *BoardState `json:"board,omitempty"`
+	Doc    *DocEvent   `json:"doc,omitempty"`
+	Alert  *AlertEvent `json:"alert,omitempty"`
+}
+
+// Broker 在实例之间分发 SSEHub 事件：任一实例发布的事件会送达全部实例上同名命名空间的订阅者
+// 本实例的订阅者总是在 Publish 内同步收到，保证本地事件的顺序不受外部依赖影响
+type Broker interface {
+	// ID 返回本实例的 InstanceID，Publish 以其作为事件的 Origin
+	ID() string
+	Publish(ns string, ev BrokerEvent)
+	// Subscribe 登记命名空间的事件处理函数，返回取消订阅的函数
+	Subscribe(ns string, fn func(BrokerEvent)) (cancel func())
+	Close() error
+}
+
+// NewBroker 按配置创建 Broker
+func NewBroker(cfg Config) (Broker, error) {
+	switch cfg.Broker {
+	case "memory":
+		return NewMemoryBroker(cfg.InstanceID), nil
+	case "redis":
+		return NewRedisBroker(cfg)
+	}
+	return nil, fmt.Errorf("unknown broker %q", cfg.Broker)
+}
+
+// MemoryBroker 为进程内的 Broker，只在本实例内分发
+type MemoryBroker struct {
+	id     string
+	mu     sync.RWMutex
+	subs   map[string]map[int]func(BrokerEvent)
+	nextID int
+}
+
+// NewMemoryBroker 创建 MemoryBroker
+func NewMemoryBroker(id string) *MemoryBroker {
+	return &MemoryBroker{id: id, subs: make(map[string]map[int]func(BrokerEvent))}
+}
+
+// ID 返回本实例的 InstanceID
+func (b *MemoryBroker) ID() string {
+	return b.id
+}
+
+// Publish 同步调用命名空间的全部订阅者
+func (b *MemoryBroker) Publish(ns string, ev BrokerEvent) {
+	ev.Origin = b.id
+	b.deliver(ns, ev)
+}
+
+// deliver 将事件交给命名空间的订阅者，Origin 保持不变
+func (b *MemoryBroker) deliver(ns string, ev BrokerEvent) {
+	b.mu.RLock()
+	fns := make([]func(BrokerEvent), 0, len(b.subs[ns]))
+	for _, fn := range b.subs[ns] {
+		fns = append(fns, fn)
+	}
+	b.mu.RUnlock()
+	for _, fn := range fns {
+		fn(ev)
+	}
+}
+
+// Subscribe 登记订阅者
+func (b *MemoryBroker) Subscribe(ns string, fn func(BrokerEvent)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	if b.subs[ns] == nil {
+		b.subs[ns] = make(map[int]func(BrokerEvent))
+	}
+	b.subs[ns][id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[ns], id)
+		if len(b.subs[ns]) == 0 {
+			delete(b.subs, ns)
+		}
+	}
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// redisMessage 为待发往 Redis 的事件
+type redisMessage struct {
+	channel string
+	payload []byte
+}
+
+// RedisBroker 通过 Redis pub/sub 在实例之间分发事件，频道为 <前缀>:<命名空间>
+// 本地订阅者由内嵌的 MemoryBroker 同步分发；发往 Redis 的事件经缓冲异步发送，不阻塞调用方，
+// 缓冲已满时丢弃并记录日志，其他实例的客户端会在下一个榜单事件中得到最新状态
+type RedisBroker struct {
+	*MemoryBroker
+	prefix string
+	rdb    *redis.Client
+	ps     *redis.PubSub
+	out    chan redisMessage
+	stop   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRedisBroker 连接 Redis 并订阅全部命名空间的频道
+func NewRedisBroker(cfg Config) (*RedisBroker, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		cancel()
+		_ = rdb.Close()
+		return nil, fmt.Errorf("redis broker: %w", err)
+	}
+	// 频道只用于广播，不依赖 DB，按前缀区分不同部署
+	ps := rdb.PSubscribe(ctx, cfg.BrokerChannel+":*")
+	if _, err := ps.Receive(ctx); err != nil {
+		cancel()
+		_ = rdb.Close()
+		return nil, fmt.Errorf("redis broker: %w", err)
+	}
+	b := &RedisBroker{
+		MemoryBroker: NewMemoryBroker(cfg.InstanceID),
+		prefix:       cfg.BrokerChannel + ":",
+		rdb:          rdb,
+		ps:           ps,
+		out:          make(chan redisMessage, cfg.BrokerBuffer),
+		stop:         make(chan struct{}),
+		cancel:       cancel,
+	}
+	b.wg.Add(2)
+	go b.sendLoop(ctx)
+	go b.receiveLoop()
+	log.Printf("redis broker ready: addr=%s channel=%s* instance=%s", cfg.RedisAddr, b.prefix, b.id)
+	return b, nil
+}
+
+// Publish 分发给本地订阅者，并异步发往 Redis
+func (b *RedisBroker) Publish(ns string, ev BrokerEvent) {
+	ev.Origin = b.id
+	b.deliver(ns, ev)
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("redis broker encode error: %v", err)
+		return
+	}
+	select {
+	case b.out <- redisMessage{channel: b.prefix + ns, payload: payload}:
+	default:
+		log.Printf("redis broker: send buffer full, event dropped (ns=%s type=%s)", ns, ev.Type)
+	}
+}
+
+// sendLoop 依次发布缓冲中的事件，保持同一实例事件的顺序
+// 停止时先发出缓冲中剩余的事件，如命名空间关闭时的 leave 通知
+func (b *RedisBroker) sendLoop(ctx context.Context) {
+	defer b.wg.Done()
+	send := func(m redisMessage) {
+		if err := b.rdb.Publish(ctx, m.channel, m.payload).Err(); err != nil && ctx.Err() == nil {
+			log.Printf("redis broker publish error: %v", err)
+		}
+	}
+	for {
+		select {
+		case m := <-b.out:
+			send(m)
+		case <-b.stop:
+			for {
+				select {
+				case m := <-b.out:
+					send(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// receiveLoop 将其他实例发布的事件分发给本地订阅者；断线由客户端自动重连并重新订阅
+func (b *RedisBroker) receiveLoop() {
+	defer b.wg.Done()
+	for msg := range b.ps.Channel() {
+		ns, ok := strings.CutPrefix(msg.Channel, b.prefix)
+		if !ok {
+			continue
+		}
+		var ev BrokerEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+			log.Printf("redis broker decode error: %v", err)
+			continue
+		}
+		if ev.Origin == b.id {
+			continue
+		}
+		b.deliver(ns, ev)
+	}
+}
+
+// Close 发出剩余事件后停止收发并断开 Redis
+func (b *RedisBroker) Close() error {
+	close(b.stop)
+	err := b.ps.Close()
+	b.wg.Wait()
+	b.cancel()
+	if cerr := b.rdb.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
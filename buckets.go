@@ -27,9 +27,14 @@ type Buckets struct {
 
 // NewBuckets 创建 Buckets
 func NewBuckets() *Buckets {
+	return newBucketsSize(1024)
+}
+
+// newBucketsSize 创建预分配 size 项的 Buckets；数量多且通常很小的榜单 (如来源榜) 传 0
+func newBucketsSize(size int) *Buckets {
 	b := &Buckets{
-		entries: make(map[string]*entry, 1024),
-		bmap:    make(map[int]*bucket, 1024),
+		entries: make(map[string]*entry, size),
+		bmap:    make(map[int]*bucket, size),
 	}
 	// 预建 count = 0 桶
 	z := &bucket{count: 0}
@@ -115,23 +120,29 @@ func (b *Buckets) Adjust(id string, delta int) int {
 	if nb == nil {
 		nb = &bucket{count: newCount}
 		b.bmap[newCount] = nb
-		// 就近插入到旧桶前后
+		// 从旧桶出发找到有序位置插入，跨越多个桶时保持升序
 		if newCount > oldCount {
-			// 旧桶之后
-			nb.prev = e.b
-			nb.next = e.b.next
-			if e.b.next != nil {
-				e.b.next.prev = nb
+			p := e.b
+			for p.next != nil && p.next.count < newCount {
+				p = p.next
+			}
+			nb.prev = p
+			nb.next = p.next
+			if p.next != nil {
+				p.next.prev = nb
 			}
-			e.b.next = nb
+			p.next = nb
 		} else {
-			// 旧桶之前
-			nb.next = e.b
-			nb.prev = e.b.prev
-			if e.b.prev != nil {
-				e.b.prev.next = nb
+			q := e.b
+			for q.prev != nil && q.prev.count > newCount {
+				q = q.prev
 			}
-			e.b.prev = nb
+			nb.next = q
+			nb.prev = q.prev
+			if q.prev != nil {
+				q.prev.next = nb
+			}
+			q.prev = nb
 		}
 	}
 
@@ -172,6 +183,11 @@ func (b *Buckets) TopK(k int) []RankItem {
 	return res
 }
 
+// Len 返回条目数
+func (b *Buckets) Len() int {
+	return len(b.entries)
+}
+
 // GetCount 返回 id 的计数
 func (b *Buckets) GetCount(id string) int {
 	if e, ok := b.entries[id]; ok {
@@ -180,6 +196,15 @@ func (b *Buckets) GetCount(id string) int {
 	return 0
 }
 
+// Counts 返回全部 id 的计数
+func (b *Buckets) Counts() map[string]int {
+	out := make(map[string]int, len(b.entries))
+	for id, e := range b.entries {
+		out[id] = e.count
+	}
+	return out
+}
+
 // ResetFromCounts 用计数快照重建桶链
 func (b *Buckets) ResetFromCounts(counts map[string]int) {
 	// 采样并排序 (按计数升序，id 次序保证稳定)
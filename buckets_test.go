@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// bucketOp 为测试中的一次操作：delta 为 0 时表示 Add，del 为真时表示 Delete
+type bucketOp struct {
+	id    string
+	delta int
+	del   bool
+}
+
+func applyBucketOps(b rankBoard, ops []bucketOp) {
+	for _, op := range ops {
+		switch {
+		case op.del:
+			b.Delete(op.id)
+		case op.delta == 0:
+			b.Add(op.id)
+		default:
+			b.Adjust(op.id, op.delta)
+		}
+	}
+}
+
+func rankString(items []RankItem) []string {
+	out := make([]string, len(items))
+	for i, it := range items {
+		out[i] = fmt.Sprintf("%s:%d", it.DocID, it.Clicks)
+	}
+	return out
+}
+
+func TestBucketsTopK(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []bucketOp
+		k    int
+		want []string
+		len  int
+	}{
+		{name: "empty", k: 3, want: []string{}},
+		{name: "zero k", ops: []bucketOp{{id: "a", delta: 1}}, k: 0, want: []string{}, len: 1},
+		{name: "added docs have zero clicks", ops: []bucketOp{{id: "a"}, {id: "b", delta: 2}}, k: 5, want: []string{"b:2", "a:0"}, len: 2},
+		{
+			name: "descending counts",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 3}, {id: "c", delta: 2}},
+			k:    3, want: []string{"b:3", "c:2", "a:1"}, len: 3,
+		},
+		{
+			name: "ties list the latest arrival first",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 1}, {id: "c", delta: 2}, {id: "c", delta: -1}},
+			k:    3, want: []string{"c:1", "b:1", "a:1"}, len: 3,
+		},
+		{
+			name: "jump over several buckets",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 2}, {id: "c", delta: 3}, {id: "a", delta: 5}},
+			k:    2, want: []string{"a:6", "c:3"}, len: 3,
+		},
+		{
+			name: "decrement below zero clamps",
+			ops:  []bucketOp{{id: "a", delta: 2}, {id: "a", delta: -5}, {id: "b", delta: 1}},
+			k:    2, want: []string{"b:1", "a:0"}, len: 2,
+		},
+		{
+			name: "decrement of unknown id is ignored",
+			ops:  []bucketOp{{id: "a", delta: -1}},
+			k:    2, want: []string{}, len: 0,
+		},
+		{
+			name: "deleting the max falls back to the next bucket",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 4}, {id: "b", del: true}},
+			k:    2, want: []string{"a:1"}, len: 1,
+		},
+		{
+			name: "max bucket emptied by a decrement",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 4}, {id: "b", delta: -4}},
+			k:    2, want: []string{"a:1", "b:0"}, len: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuckets()
+			applyBucketOps(b, tt.ops)
+			if got := rankString(b.TopK(tt.k)); !slices.Equal(got, tt.want) {
+				t.Errorf("TopK(%d) = %v, want %v", tt.k, got, tt.want)
+			}
+			if b.Len() != tt.len {
+				t.Errorf("Len() = %d, want %d", b.Len(), tt.len)
+			}
+		})
+	}
+}
+
+func TestBucketsResetFromCounts(t *testing.T) {
+	b := NewBuckets()
+	b.ResetFromCounts(map[string]int{"a": 2, "b": 5, "c": 0, "d": -1})
+	if got, want := rankString(b.TopK(2)), []string{"b:5", "a:2"}; !slices.Equal(got, want) {
+		t.Errorf("TopK(2) = %v, want %v", got, want)
+	}
+	if b.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", b.Len())
+	}
+	// 重建后继续调整，桶链仍有序
+	b.Adjust("c", 3)
+	b.Adjust("b", -4)
+	if got, want := rankString(b.TopK(3)), []string{"c:3", "a:2", "b:1"}; !slices.Equal(got, want) {
+		t.Errorf("TopK(3) after adjust = %v, want %v", got, want)
+	}
+}
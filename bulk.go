@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// bulkRow 表示解析出的一行导入数据
+type bulkRow struct {
+	row int
+	doc Doc
+}
+
+// detectFormat 依据 format 参数或 Content-Type 判断数据格式，默认 JSON
+func detectFormat(format, contentType string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case formatJSON:
+		return formatJSON, true
+	case formatNDJSON, "jsonl":
+		return formatNDJSON, true
+	case formatCSV:
+		return formatCSV, true
+	case "":
+	default:
+		return "", false
+	}
+	ct := strings.ToLower(contentType)
+	switch {
+	case strings.Contains(ct, "ndjson"), strings.Contains(ct, "jsonl"):
+		return formatNDJSON, true
+	case strings.Contains(ct, "csv"):
+		return formatCSV, true
+	}
+	return formatJSON, true
+}
+
+// parseBulkDocs 解析批量导入数据，返回合法行与逐行错误
+// 行数在读取过程中检查，超过 maxRows 立即停止，避免整份上传先读入内存
+func parseBulkDocs(format string, r io.Reader, maxRows int) ([]bulkRow, []BulkRowError, error) {
+	switch format {
+	case formatNDJSON:
+		return parseNDJSONDocs(r, maxRows)
+	case formatCSV:
+		return parseCSVDocs(r, maxRows)
+	}
+	return parseJSONDocs(r, maxRows)
+}
+
+// checkRows 在已读行数 n 超过上限时返回错误
+func checkRows(n, maxRows int) error {
+	if maxRows > 0 && n > maxRows {
+		return fmt.Errorf("too many rows: limit is %d", maxRows)
+	}
+	return nil
+}
+
+// validateBulkDoc 校验单行文档
+func validateBulkDoc(row int, req UpsertDocReq) (bulkRow, *BulkRowError) {
+	id := strings.TrimSpace(req.ID)
+	if id == "" {
+		return bulkRow{}, &BulkRowError{Row: row, Message: "id is required"}
+	}
+	return bulkRow{row: row, doc: Doc{ID: id, Title: req.Title, URL: req.URL, Meta: req.Meta}}, nil
+}
+
+// parseJSONDocs 逐个元素解码 JSON 数组
+func parseJSONDocs(r io.Reader, maxRows int) ([]bulkRow, []BulkRowError, error) {
+	dec := json.NewDecoder(r)
+	t, err := dec.Token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid json array: %w", err)
+	}
+	if t != json.Delim('[') {
+		return nil, nil, errors.New("invalid json array")
+	}
+	var (
+		rows []bulkRow
+		errs []BulkRowError
+	)
+	for n := 1; dec.More(); n++ {
+		if err := checkRows(n, maxRows); err != nil {
+			return nil, nil, err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, fmt.Errorf("invalid json array: %w", err)
+		}
+		var req UpsertDocReq
+		if err := json.Unmarshal(raw, &req); err != nil {
+			errs = append(errs, BulkRowError{Row: n, Message: "invalid json object"})
+			continue
+		}
+		row, rowErr := validateBulkDoc(n, req)
+		if rowErr != nil {
+			errs = append(errs, *rowErr)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("invalid json array: %w", err)
+	}
+	return rows, errs, nil
+}
+
+func parseNDJSONDocs(r io.Reader, maxRows int) ([]bulkRow, []BulkRowError, error) {
+	var (
+		rows []bulkRow
+		errs []BulkRowError
+	)
+	reader := bufio.NewReader(r)
+	n := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			// 空行不计入行号
+			n++
+			if err := checkRows(n, maxRows); err != nil {
+				return nil, nil, err
+			}
+			var req UpsertDocReq
+			if jerr := json.Unmarshal(line, &req); jerr != nil {
+				errs = append(errs, BulkRowError{Row: n, Message: "invalid json object"})
+			} else if row, rowErr := validateBulkDoc(n, req); rowErr != nil {
+				errs = append(errs, *rowErr)
+			} else {
+				rows = append(rows, row)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+	}
+	return rows, errs, nil
+}
+
+// parseCSVDocs 解析带表头的 CSV，列顺序任意，未知列忽略
+func parseCSVDocs(r io.Reader, maxRows int) ([]bulkRow, []BulkRowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid csv header: %w", err)
+	}
+	col := map[string]int{"id": -1, "title": -1, "url": -1}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if _, ok := col[h]; ok {
+			col[h] = i
+		}
+	}
+	if col["id"] < 0 {
+		return nil, nil, errors.New("csv header must contain id column")
+	}
+	field := func(rec []string, name string) string {
+		i := col[name]
+		if i < 0 || i >= len(rec) {
+			return ""
+		}
+		return rec[i]
+	}
+
+	var (
+		rows []bulkRow
+		errs []BulkRowError
+	)
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err := checkRows(n, maxRows); err != nil {
+			return nil, nil, err
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				errs = append(errs, BulkRowError{Row: n, Message: pe.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		req := UpsertDocReq{ID: field(rec, "id"), Title: field(rec, "title"), URL: field(rec, "url")}
+		row, rowErr := validateBulkDoc(n, req)
+		if rowErr != nil {
+			errs = append(errs, *rowErr)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs, nil
+}
+
+// exportContentType 返回导出格式对应的 Content-Type
+func exportContentType(format string) string {
+	switch format {
+	case formatNDJSON:
+		return "application/x-ndjson; charset=utf-8"
+	case formatCSV:
+		return "text/csv; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+// rowEncoder 按格式逐行写出导出数据，JSON 以数组形式输出
+type rowEncoder struct {
+	w      io.Writer
+	format string
+	csv    *csv.Writer
+	n      int
+}
+
+// newRowEncoder 创建逐行编码器，CSV 时先写表头
+func newRowEncoder(w io.Writer, format string, header []string) (*rowEncoder, error) {
+	e := &rowEncoder{w: w, format: format}
+	switch format {
+	case formatCSV:
+		e.csv = csv.NewWriter(w)
+		if err := e.csv.Write(header); err != nil {
+			return nil, err
+		}
+	case formatNDJSON:
+	default:
+		e.format = formatJSON
+		if _, err := io.WriteString(w, "["); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Write 写出一行，v 用于 JSON 格式，rec 用于 CSV 格式
+func (e *rowEncoder) Write(v any, rec []string) error {
+	defer func() { e.n++ }()
+	switch e.format {
+	case formatCSV:
+		return e.csv.Write(rec)
+	case formatNDJSON:
+		return json.NewEncoder(e.w).Encode(v)
+	}
+	if e.n > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+// Flush 将已缓冲的行写出
+func (e *rowEncoder) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
+
+// Close 结束输出
+func (e *rowEncoder) Close() error {
+	if err := e.Flush(); err != nil {
+		return err
+	}
+	if e.format == formatJSON {
+		_, err := io.WriteString(e.w, "]\n")
+		return err
+	}
+	return nil
+}
+
+// writeDocsExport 逐行写出文档导出数据
+func writeDocsExport(w io.Writer, format string, items []DocExportItem) error {
+	enc, err := newRowEncoder(w, format, []string{"id", "title", "url", "clicks", "recent_clicks"})
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		rec := []string{it.ID, it.Title, it.URL, strconv.Itoa(it.Clicks), strconv.Itoa(it.RecentClicks)}
+		if err := enc.Write(it, rec); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
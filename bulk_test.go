@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseBulkDocs(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		body    string
+		maxRows int
+		ids     []string
+		errRows []int
+		wantErr bool
+	}{
+		{name: "json", format: formatJSON, body: `[{"id":"a","title":"A"},{"id":" b "}]`, ids: []string{"a", "b"}},
+		{name: "json empty", format: formatJSON, body: `[]`},
+		{name: "json row errors", format: formatJSON, body: `[{"id":""},1,{"id":"c"}]`, ids: []string{"c"}, errRows: []int{1, 2}},
+		{name: "json not array", format: formatJSON, body: `{"id":"a"}`, wantErr: true},
+		{name: "json truncated", format: formatJSON, body: `[{"id":"a"}`, wantErr: true},
+		{name: "json at limit", format: formatJSON, body: `[{"id":"a"},{"id":"b"}]`, maxRows: 2, ids: []string{"a", "b"}},
+		{name: "json over limit", format: formatJSON, body: `[{"id":"a"},{"id":"b"},{"id":"c"}]`, maxRows: 2, wantErr: true},
+		{name: "ndjson", format: formatNDJSON, body: "{\"id\":\"a\"}\n\n{\"id\":\"b\"}", ids: []string{"a", "b"}},
+		{name: "ndjson row errors", format: formatNDJSON, body: "not json\n{\"title\":\"x\"}\n{\"id\":\"c\"}\n", ids: []string{"c"}, errRows: []int{1, 2}},
+		{name: "ndjson blank lines not counted", format: formatNDJSON, body: "\n{\"id\":\"a\"}\n\n\n{\"id\":\"b\"}\n", maxRows: 2, ids: []string{"a", "b"}},
+		{name: "ndjson over limit", format: formatNDJSON, body: "{\"id\":\"a\"}\n{\"id\":\"b\"}\n{\"id\":\"c\"}\n", maxRows: 2, wantErr: true},
+		{name: "csv", format: formatCSV, body: "\ufeffTitle,ID,extra\nA,a,x\nB,b,y\n", ids: []string{"a", "b"}},
+		{name: "csv short row", format: formatCSV, body: "id,title,url\na\n", ids: []string{"a"}},
+		{name: "csv missing id", format: formatCSV, body: "id,title\n,A\nb,B\n", ids: []string{"b"}, errRows: []int{1}},
+		{name: "csv no id column", format: formatCSV, body: "title\nA\n", wantErr: true},
+		{name: "csv over limit", format: formatCSV, body: "id\na\nb\nc\n", maxRows: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, errs, err := parseBulkDocs(tt.format, strings.NewReader(tt.body), tt.maxRows)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got %d rows", len(rows))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var ids []string
+			for _, r := range rows {
+				ids = append(ids, r.doc.ID)
+			}
+			if !slices.Equal(ids, tt.ids) {
+				t.Errorf("ids = %v, want %v", ids, tt.ids)
+			}
+			var errRows []int
+			for _, e := range errs {
+				errRows = append(errRows, e.Row)
+			}
+			if !slices.Equal(errRows, tt.errRows) {
+				t.Errorf("error rows = %v, want %v", errRows, tt.errRows)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		format, contentType string
+		want                string
+		ok                  bool
+	}{
+		{"", "", formatJSON, true},
+		{"CSV", "application/json", formatCSV, true},
+		{"jsonl", "", formatNDJSON, true},
+		{"", "application/x-ndjson", formatNDJSON, true},
+		{"", "text/csv; charset=utf-8", formatCSV, true},
+		{"xml", "", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := detectFormat(tt.format, tt.contentType)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("detectFormat(%q, %q) = %q, %v; want %q, %v", tt.format, tt.contentType, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClickTokenMissing = errors.New("click token required")
+	ErrClickTokenInvalid = errors.New("invalid click token")
+	ErrClickTokenExpired = errors.New("click token expired")
+	ErrClickTokenUsed    = errors.New("click token already used")
+)
+
+// ClickTokens 签发与校验点击令牌
+// 令牌格式为 <过期时间>.<随机数>.<签名>，签名覆盖 命名空间、文档 ID、过期时间与随机数；
+// 第一个密钥用于签发，全部密钥均可校验，便于轮换。未配置密钥时不校验令牌。
+// 已使用的随机数只记录在本进程内，多实例部署时同一令牌在每个实例上各可使用一次
+type ClickTokens struct {
+	secrets [][]byte
+	ttl     time.Duration
+
+	mu   sync.Mutex
+	used map[string]int64 // 随机数 -> 过期时间，过期前拒绝重放
+}
+
+// NewClickTokens 创建点击令牌
+func NewClickTokens(secrets []string, ttl time.Duration) *ClickTokens {
+	t := &ClickTokens{ttl: ttl, used: make(map[string]int64)}
+	for _, s := range secrets {
+		t.secrets = append(t.secrets, []byte(s))
+	}
+	return t
+}
+
+// Enabled 判断是否要求点击令牌
+func (t *ClickTokens) Enabled() bool {
+	return len(t.secrets) > 0
+}
+
+// sign 计算签名；各字段带长度前缀，文档 ID 含任意字符时字段边界也不会混淆
+func (t *ClickTokens) sign(secret []byte, ns, docID, exp, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, f := range []string{ns, docID, exp, nonce} {
+		mac.Write([]byte(strconv.Itoa(len(f)) + ":" + f))
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue 为命名空间内的文档签发令牌
+func (t *ClickTokens) Issue(ns, docID string, now time.Time) (string, time.Time) {
+	expAt := now.Add(t.ttl)
+	exp := strconv.FormatInt(expAt.Unix(), 10)
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	return exp + "." + nonce + "." + t.sign(t.secrets[0], ns, docID, exp, nonce), expAt
+}
+
+// Verify 校验令牌的签名、文档 ID 与过期时间，通过后将其标记为已使用
+func (t *ClickTokens) Verify(ns, docID, token string, now time.Time) error {
+	if token == "" {
+		return ErrClickTokenMissing
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrClickTokenInvalid
+	}
+	exp, nonce, sig := parts[0], parts[1], parts[2]
+	valid := false
+	for _, secret := range t.secrets {
+		if hmac.Equal([]byte(sig), []byte(t.sign(secret, ns, docID, exp, nonce))) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrClickTokenInvalid
+	}
+	expSec, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrClickTokenInvalid
+	}
+	nowSec := now.Unix()
+	if nowSec > expSec {
+		return ErrClickTokenExpired
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.used[nonce]; ok {
+		return ErrClickTokenUsed
+	}
+	t.used[nonce] = expSec
+	return nil
+}
+
+// Prune 清理已过期的随机数
+func (t *ClickTokens) Prune(now time.Time) {
+	nowSec := now.Unix()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for nonce, exp := range t.used {
+		if nowSec > exp {
+			delete(t.used, nonce)
+		}
+	}
+}
+
+// StartPruner 周期清理已过期的随机数
+func (t *ClickTokens) StartPruner(stop <-chan struct{}) {
+	if !t.Enabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				t.Prune(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Coalescer 合并短时间内的多次触发，保证最后一次触发之后总会执行一次
+// 距上次执行已超过间隔时立即执行 (leading)；否则在间隔结束时执行一次 (trailing)，
+// 期间的多次触发只执行一次。执行时读取最新状态，因此合并不会丢失最终结果
+type Coalescer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	fn       func() // trailing 执行，由定时器调用，需自行加锁
+	last     time.Time
+	timer    *time.Timer
+}
+
+// NewCoalescer 创建合并器
+func NewCoalescer(interval time.Duration, fn func()) *Coalescer {
+	return &Coalescer{interval: interval, fn: fn}
+}
+
+// Trigger 请求一次执行；返回 true 时由调用方立即执行 (通常已持有所需的锁)
+func (c *Coalescer) Trigger() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		// 已安排 trailing 执行
+		return false
+	}
+	now := time.Now()
+	if wait := c.interval - now.Sub(c.last); wait > 0 {
+		c.timer = time.AfterFunc(wait, c.fire)
+		return false
+	}
+	c.last = now
+	return true
+}
+
+func (c *Coalescer) fire() {
+	c.mu.Lock()
+	c.timer = nil
+	c.last = time.Now()
+	c.mu.Unlock()
+	c.fn()
+}
+
+// Stop 取消尚未执行的 trailing
+func (c *Coalescer) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescer(t *testing.T) {
+	const interval = 40 * time.Millisecond
+	tests := []struct {
+		name     string
+		triggers []time.Duration // 每次触发前的等待
+		stop     bool            // 触发后立即 Stop
+		leading  []bool          // 各次 Trigger 的返回值
+		trailing int32           // 最终 fn 的执行次数
+	}{
+		{name: "first trigger runs immediately", triggers: []time.Duration{0}, leading: []bool{true}},
+		{
+			name:     "burst within the interval runs once at the end",
+			triggers: []time.Duration{0, 0, 5 * time.Millisecond, 5 * time.Millisecond},
+			leading:  []bool{true, false, false, false}, trailing: 1,
+		},
+		{
+			name:     "trigger after the interval runs immediately again",
+			triggers: []time.Duration{0, interval + 10*time.Millisecond},
+			leading:  []bool{true, true},
+		},
+		{
+			name:     "stop cancels the pending trailing run",
+			triggers: []time.Duration{0, 0}, stop: true,
+			leading: []bool{true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c := NewCoalescer(interval, func() { calls.Add(1) })
+			for i, wait := range tt.triggers {
+				time.Sleep(wait)
+				if got := c.Trigger(); got != tt.leading[i] {
+					t.Errorf("Trigger #%d = %v, want %v", i, got, tt.leading[i])
+				}
+			}
+			if tt.stop {
+				c.Stop()
+			}
+			time.Sleep(3 * interval)
+			if got := calls.Load(); got != tt.trailing {
+				t.Errorf("trailing runs = %d, want %d", got, tt.trailing)
+			}
+		})
+	}
+}
+
+func TestCoalescerTrailingResetsInterval(t *testing.T) {
+	const interval = 40 * time.Millisecond
+	var calls atomic.Int32
+	c := NewCoalescer(interval, func() { calls.Add(1) })
+	c.Trigger()
+	c.Trigger()
+	time.Sleep(interval + 20*time.Millisecond)
+	if calls.Load() != 1 {
+		t.Fatalf("trailing runs = %d, want 1", calls.Load())
+	}
+	// trailing 执行刚结束，紧接着的触发应再次延后而不是立即执行
+	if c.Trigger() {
+		t.Error("trigger right after a trailing run should be deferred")
+	}
+	time.Sleep(3 * interval)
+	if calls.Load() != 2 {
+		t.Errorf("trailing runs = %d, want 2", calls.Load())
+	}
+}
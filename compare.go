@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// buildCompare 合并两个区间的计数，计算变化量并选出涨幅与跌幅最大的前 k 项
+func buildCompare(cur, prev map[string]int, k int) (items, risers, fallers []CompareItem) {
+	items = make([]CompareItem, 0, len(cur)+len(prev))
+	add := func(id string) {
+		it := CompareItem{DocID: id, Current: cur[id], Previous: prev[id]}
+		it.Change = it.Current - it.Previous
+		if it.Previous > 0 {
+			pct := float64(it.Change) / float64(it.Previous) * 100
+			it.ChangePct = &pct
+		}
+		items = append(items, it)
+	}
+	for id := range cur {
+		add(id)
+	}
+	for id := range prev {
+		if _, ok := cur[id]; !ok {
+			add(id)
+		}
+	}
+	// 按变化量降序，同值按 ID 升序
+	slices.SortFunc(items, func(p, q CompareItem) int {
+		if p.Change != q.Change {
+			return q.Change - p.Change
+		}
+		return strings.Compare(p.DocID, q.DocID)
+	})
+
+	risers = make([]CompareItem, 0, k)
+	for _, it := range items {
+		if it.Change <= 0 || len(risers) >= k {
+			break
+		}
+		risers = append(risers, it)
+	}
+	fallers = make([]CompareItem, 0, k)
+	for i := len(items) - 1; i >= 0; i-- {
+		if items[i].Change >= 0 || len(fallers) >= k {
+			break
+		}
+		fallers = append(fallers, items[i])
+	}
+	return items, risers, fallers
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,8 +11,128 @@ type Config struct {
 	Port              string
 	DataDir           string
 	TopKDefault       int
+	SSETopK           int           // SSE 推送的榜单长度
+	SSEReplaySize     int           // SSE 断线补发缓冲的事件数
+	SSERankInterval   time.Duration // 榜单差异的最小推送间隔，间隔内的变化合并推送
+	SSEDocInterval    time.Duration // 文档变更的最小推送间隔，0 为逐条推送
+	SSESlowPolicy     string        // 慢客户端处理方式：resync 或 disconnect
+	SSESlowMaxDrops   int           // disconnect 方式下累计丢弃多少事件后断开
 	SnapshotInterval  time.Duration
 	WALSyncEveryWrite bool
+	BulkMaxRows       int
+	BulkMaxBytes      int64 // 批量导入请求体上限，防止单行过大
+
+	// 榜单快照，用于计算名次变化
+	RankSnapshotInterval  time.Duration
+	RankSnapshotRetention time.Duration
+	RankSnapshotDepth     int
+
+	// 历史榜单归档
+	ArchiveInterval       time.Duration
+	ArchiveRetention      time.Duration
+	ArchiveFullResolution time.Duration // 此时长之内保留全部快照，更早的每小时保留一个
+	ArchiveTopK           int
+
+	// 点击时间序列各粒度保留期
+	TSMinuteRetention time.Duration
+	TSHourRetention   time.Duration
+	TSDayRetention    time.Duration
+
+	// 点击来源：每个维度的取值上限与单个取值最大长度
+	SourceMaxValues   int
+	SourceMaxValueLen int
+
+	// 近似榜单：列出的榜单改用 Space-Saving + Count-Min，计数结构内存固定
+	// total 为近似时快照保存已追踪条目与 Count-Min 计数表，未追踪文档的计数按 Count-Min 估计
+	ApproxBoards   []string
+	ApproxCapacity int
+	ApproxEpsilon  float64
+	ApproxDelta    float64
+
+	// 自动登记：点击未知 ID 时创建占位文档
+	AutoRegister          bool
+	AutoRegisterIDPattern string
+	AutoRegisterHosts     []string
+	AutoRegisterMaxDocs   int
+	AutoRegisterPerMinute int
+
+	// 命名空间：默认命名空间的文档上限 (0 不限) 与命名空间数量上限
+	MaxDocs       int
+	MaxNamespaces int
+
+	// 鉴权：API 密钥文件 (为空时不鉴权) 与变更检查间隔
+	AuthKeysFile       string
+	AuthReloadInterval time.Duration
+
+	// 点击令牌：签名密钥 (第一个用于签发，为空时不校验) 与有效期
+	ClickTokenSecrets []string
+	ClickTokenTTL     time.Duration
+
+	// 限流规则，格式见 parseRateRules
+	// 按 visitor 限流取客户端自报的 X-Visitor-ID 或 vid Cookie，客户端可随意更换，只能约束守规矩的客户端；
+	// 需要强制限流时应按 ip 或 apikey
+	RateLimits []string
+	// 可信代理的 IP 或 CIDR，只有来自这些地址的请求才采用 X-Forwarded-For / X-Real-IP 作为客户端 IP；
+	// 为空时不信任任何代理，直接使用连接地址
+	TrustedProxies []string
+
+	// 点击过滤：UA 黑名单、单个客户端 IP 突发、文档突增检测及其处理方式 (flag / quarantine / drop)
+	FilterUADeny      []string
+	FilterUAAction    string
+	FilterBurstMax    int // 0 关闭
+	FilterBurstWindow time.Duration
+	FilterBurstAction string
+	FilterSpikeFactor float64 // 0 关闭
+	FilterSpikeMin    int
+	FilterSpikeAction string
+	QuarantineMax     int
+	FilterFlagLog     int
+
+	// WebSocket：心跳间隔 (超过两倍间隔未收到 pong 即断开)、每连接发送缓冲、单条消息上限与允许的 Origin
+	WSPingInterval   time.Duration
+	WSSendBuffer     int
+	WSMaxMessage     int
+	WSAllowedOrigins []string // 为空时只允许同源或不带 Origin 的客户端，* 允许全部
+
+	// 事件分发：memory 只在本实例内，redis 经 Redis pub/sub 送达全部实例
+	// 各实例有独立的存储，SSE 榜单由各实例发布的榜单按文档合并而成，InstanceID 用于区分实例，
+	// 需各实例唯一且重启后不变 (默认取主机名)，否则重启前发布的榜单会被重复计入。
+	// 只有 SSE/WebSocket 推送的榜单是合并后的；/rank、导出、区间与时间序列等 REST 查询只读本实例的存储
+	InstanceID    string
+	Broker        string
+	BrokerChannel string // Redis 频道前缀
+	BrokerBuffer  int    // 发往 Redis 的发送缓冲
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+
+	// 回调：请求超时、并发数、重试次数与退避、队列与投递记录长度、每个命名空间的回调数上限
+	// 默认拒绝投递到回环、内网、链路本地 (含云元数据地址) 等地址，WebhookAllowPrivate 为 true 时放开
+	WebhookTimeout      time.Duration
+	WebhookAllowPrivate bool
+	WebhookConcurrency  int
+	WebhookMaxAttempts  int
+	WebhookBackoff      time.Duration // 第一次重试的间隔，之后逐次翻倍
+	WebhookBackoffMax   time.Duration
+	WebhookQueueMax     int
+	WebhookLogSize      int
+	WebhookMaxHooks     int
+
+	// 告警：规则评估间隔、每个命名空间的规则数上限与保留的状态变化记录数
+	// 多实例部署时只应有一个实例开启 AlertEvaluator，规则保存在该实例并由它评估，状态变化经 Broker 推送到全部实例；
+	// 规则只按该实例自己的存储评估，阈值、名次与无点击规则都不包含其他实例的点击
+	AlertEvaluator    bool
+	AlertEvalInterval time.Duration
+	AlertMaxRules     int
+	AlertHistory      int
+}
+
+// defaultInstanceID 取主机名，容器重启后保持不变；取不到时随机生成
+func defaultInstanceID() string {
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	return randomID(8)
 }
 
 func getenv(key, def string) string {
@@ -35,13 +156,117 @@ func mustParseDuration(s string, def time.Duration) time.Duration {
 	return def
 }
 
+func mustParseFloat(s string, def float64) float64 {
+	if v, err := strconv.ParseFloat(s, 64); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 // LoadConfig 加载配置
 func LoadConfig() Config {
 	return Config{
 		Port:              getenv("PORT", "8080"),
 		DataDir:           getenv("DATA_DIR", "./data"),
 		TopKDefault:       mustAtoi(getenv("TOPK_DEFAULT", "100"), 100),
+		SSETopK:           mustAtoi(getenv("SSE_TOPK", "50"), 50),
+		SSEReplaySize:     mustAtoi(getenv("SSE_REPLAY_SIZE", "1024"), 1024),
+		SSERankInterval:   mustParseDuration(getenv("SSE_RANK_INTERVAL", "100ms"), 100*time.Millisecond),
+		SSEDocInterval:    mustParseDuration(getenv("SSE_DOC_INTERVAL", "0s"), 0),
+		SSESlowPolicy:     getenv("SSE_SLOW_POLICY", "resync"),
+		SSESlowMaxDrops:   mustAtoi(getenv("SSE_SLOW_MAX_DROPS", "100"), 100),
 		SnapshotInterval:  mustParseDuration(getenv("SNAPSHOT_INTERVAL", "60s"), 60*time.Second),
 		WALSyncEveryWrite: getenv("WAL_SYNC_EVERY_WRITE", "true") == "true",
+		BulkMaxRows:       mustAtoi(getenv("BULK_MAX_ROWS", "10000"), 10000),
+		BulkMaxBytes:      int64(mustAtoi(getenv("BULK_MAX_BYTES", "33554432"), 32<<20)),
+
+		RankSnapshotInterval:  mustParseDuration(getenv("RANK_SNAPSHOT_INTERVAL", "15s"), 15*time.Second),
+		RankSnapshotRetention: mustParseDuration(getenv("RANK_SNAPSHOT_RETENTION", "1h"), time.Hour),
+		RankSnapshotDepth:     mustAtoi(getenv("RANK_SNAPSHOT_DEPTH", "200"), 200),
+
+		ArchiveInterval:       mustParseDuration(getenv("ARCHIVE_INTERVAL", "5m"), 5*time.Minute),
+		ArchiveRetention:      mustParseDuration(getenv("ARCHIVE_RETENTION", "720h"), 720*time.Hour),
+		ArchiveFullResolution: mustParseDuration(getenv("ARCHIVE_FULL_RESOLUTION", "24h"), 24*time.Hour),
+		ArchiveTopK:           mustAtoi(getenv("ARCHIVE_TOPK", "100"), 100),
+
+		TSMinuteRetention: mustParseDuration(getenv("TS_MINUTE_RETENTION", "24h"), 24*time.Hour),
+		TSHourRetention:   mustParseDuration(getenv("TS_HOUR_RETENTION", "720h"), 720*time.Hour),
+		TSDayRetention:    mustParseDuration(getenv("TS_DAY_RETENTION", "8760h"), 8760*time.Hour),
+
+		SourceMaxValues:   mustAtoi(getenv("SOURCE_MAX_VALUES", "1000"), 1000),
+		SourceMaxValueLen: mustAtoi(getenv("SOURCE_MAX_VALUE_LEN", "256"), 256),
+
+		ApproxBoards:   splitList(getenv("APPROX_BOARDS", "")),
+		ApproxCapacity: mustAtoi(getenv("APPROX_CAPACITY", "1000"), 1000),
+		ApproxEpsilon:  mustParseFloat(getenv("APPROX_CMS_EPSILON", "0.001"), 0.001),
+		ApproxDelta:    mustParseFloat(getenv("APPROX_CMS_DELTA", "0.01"), 0.01),
+
+		AutoRegister:          getenv("AUTO_REGISTER", "false") == "true",
+		AutoRegisterIDPattern: getenv("AUTO_REGISTER_ID_PATTERN", ""),
+		AutoRegisterHosts:     splitList(getenv("AUTO_REGISTER_URL_HOSTS", "")),
+		AutoRegisterMaxDocs:   mustAtoi(getenv("AUTO_REGISTER_MAX_DOCS", "10000"), 10000),
+		AutoRegisterPerMinute: mustAtoi(getenv("AUTO_REGISTER_PER_MINUTE", "60"), 60),
+
+		MaxDocs:       mustAtoi(getenv("MAX_DOCS", "0"), 0),
+		MaxNamespaces: mustAtoi(getenv("NS_MAX", "100"), 100),
+
+		AuthKeysFile:       getenv("AUTH_KEYS_FILE", ""),
+		AuthReloadInterval: mustParseDuration(getenv("AUTH_RELOAD_INTERVAL", "10s"), 10*time.Second),
+
+		ClickTokenSecrets: splitList(getenv("CLICK_TOKEN_SECRETS", "")),
+		ClickTokenTTL:     mustParseDuration(getenv("CLICK_TOKEN_TTL", "10m"), 10*time.Minute),
+
+		RateLimits:     splitList(getenv("RATE_LIMITS", "")),
+		TrustedProxies: splitList(getenv("TRUSTED_PROXIES", "")),
+
+		FilterUADeny:      splitList(getenv("FILTER_UA_DENY", "")),
+		FilterUAAction:    getenv("FILTER_UA_ACTION", "drop"),
+		FilterBurstMax:    mustAtoi(getenv("FILTER_BURST_MAX", "0"), 0),
+		FilterBurstWindow: mustParseDuration(getenv("FILTER_BURST_WINDOW", "10s"), 10*time.Second),
+		FilterBurstAction: getenv("FILTER_BURST_ACTION", "quarantine"),
+		FilterSpikeFactor: mustParseFloat(getenv("FILTER_SPIKE_FACTOR", "0"), 0),
+		FilterSpikeMin:    mustAtoi(getenv("FILTER_SPIKE_MIN", "50"), 50),
+		FilterSpikeAction: getenv("FILTER_SPIKE_ACTION", "flag"),
+		QuarantineMax:     mustAtoi(getenv("QUARANTINE_MAX", "10000"), 10000),
+		FilterFlagLog:     mustAtoi(getenv("FILTER_FLAG_LOG", "1000"), 1000),
+
+		WSPingInterval:   mustParseDuration(getenv("WS_PING_INTERVAL", "25s"), 25*time.Second),
+		WSSendBuffer:     mustAtoi(getenv("WS_SEND_BUFFER", "64"), 64),
+		WSMaxMessage:     mustAtoi(getenv("WS_MAX_MESSAGE", "4096"), 4096),
+		WSAllowedOrigins: splitList(getenv("WS_ALLOWED_ORIGINS", "")),
+
+		InstanceID:    getenv("INSTANCE_ID", defaultInstanceID()),
+		Broker:        getenv("BROKER", "memory"),
+		BrokerChannel: getenv("BROKER_CHANNEL", "docrank:events"),
+		BrokerBuffer:  mustAtoi(getenv("BROKER_BUFFER", "1024"), 1024),
+		RedisAddr:     getenv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword: getenv("REDIS_PASSWORD", ""),
+		RedisDB:       mustAtoi(getenv("REDIS_DB", "0"), 0),
+
+		WebhookTimeout:      mustParseDuration(getenv("WEBHOOK_TIMEOUT", "10s"), 10*time.Second),
+		WebhookAllowPrivate: getenv("WEBHOOK_ALLOW_PRIVATE", "false") == "true",
+		WebhookConcurrency:  mustAtoi(getenv("WEBHOOK_CONCURRENCY", "4"), 4),
+		WebhookMaxAttempts:  mustAtoi(getenv("WEBHOOK_MAX_ATTEMPTS", "8"), 8),
+		WebhookBackoff:      mustParseDuration(getenv("WEBHOOK_BACKOFF", "10s"), 10*time.Second),
+		WebhookBackoffMax:   mustParseDuration(getenv("WEBHOOK_BACKOFF_MAX", "1h"), time.Hour),
+		WebhookQueueMax:     mustAtoi(getenv("WEBHOOK_QUEUE_MAX", "10000"), 10000),
+		WebhookLogSize:      mustAtoi(getenv("WEBHOOK_LOG_SIZE", "500"), 500),
+		WebhookMaxHooks:     mustAtoi(getenv("WEBHOOK_MAX_HOOKS", "50"), 50),
+
+		AlertEvaluator:    getenv("ALERT_EVALUATOR", "true") == "true",
+		AlertEvalInterval: mustParseDuration(getenv("ALERT_EVAL_INTERVAL", "5s"), 5*time.Second),
+		AlertMaxRules:     mustAtoi(getenv("ALERT_MAX_RULES", "100"), 100),
+		AlertHistory:      mustAtoi(getenv("ALERT_HISTORY", "200"), 200),
 	}
 }
@@ -0,0 +1,118 @@
+package main
+
+import "time"
+
+// rankPos 记录快照时某文档的名次与计数
+type rankPos struct {
+	Rank   int
+	Clicks int
+}
+
+// rankSnapshot 是某一时刻各榜单前若干项的快照
+type rankSnapshot struct {
+	Version uint64
+	At      time.Time
+	Boards  map[string]map[string]rankPos
+}
+
+// RankHistory 按时间顺序保存周期性的榜单快照，用于计算名次变化
+type RankHistory struct {
+	snaps     []*rankSnapshot // 按时间升序
+	retention time.Duration
+	lastVer   uint64
+}
+
+// NewRankHistory 创建榜单快照历史
+func NewRankHistory(retention time.Duration) *RankHistory {
+	return &RankHistory{retention: retention}
+}
+
+// Add 追加一个快照并清理过期快照，返回分配的版本号
+// 版本号取毫秒时间戳并保证递增，重启后仍可与旧版本区分
+func (h *RankHistory) Add(at time.Time, boards map[string]map[string]rankPos) uint64 {
+	v := uint64(at.UnixMilli())
+	if v <= h.lastVer {
+		v = h.lastVer + 1
+	}
+	h.lastVer = v
+	h.snaps = append(h.snaps, &rankSnapshot{Version: v, At: at, Boards: boards})
+
+	// 保留窗口外的快照中最新的一个，使“整段保留期之前”仍有基准
+	cutoff := at.Add(-h.retention)
+	drop := 0
+	for drop+1 < len(h.snaps) && !h.snaps[drop+1].At.After(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		h.snaps = append(h.snaps[:0], h.snaps[drop:]...)
+	}
+	return v
+}
+
+// Latest 返回最新快照
+func (h *RankHistory) Latest() (*rankSnapshot, bool) {
+	if len(h.snaps) == 0 {
+		return nil, false
+	}
+	return h.snaps[len(h.snaps)-1], true
+}
+
+// Before 返回不晚于 t 的最新快照；若全部晚于 t，则返回最早的快照
+func (h *RankHistory) Before(t time.Time) (*rankSnapshot, bool) {
+	if len(h.snaps) == 0 {
+		return nil, false
+	}
+	for i := len(h.snaps) - 1; i >= 0; i-- {
+		if !h.snaps[i].At.After(t) {
+			return h.snaps[i], true
+		}
+	}
+	return h.snaps[0], true
+}
+
+// ByVersion 按版本号查找快照
+func (h *RankHistory) ByVersion(v uint64) (*rankSnapshot, bool) {
+	for i := len(h.snaps) - 1; i >= 0; i-- {
+		if h.snaps[i].Version == v {
+			return h.snaps[i], true
+		}
+	}
+	return nil, false
+}
+
+// applyDeltas 以快照中的榜单为基准，为条目填充名次与计数变化
+// 基准只保存前若干项，未出现在基准中的条目标记为新上榜
+func applyDeltas(items []RankItem, base map[string]rankPos) {
+	for i := range items {
+		it := &items[i]
+		p, ok := base[it.DocID]
+		if !ok {
+			it.New = true
+			continue
+		}
+		it.PrevRank = p.Rank
+		it.RankChange = p.Rank - (i + 1)
+		it.ClickDelta = it.Clicks - p.Clicks
+	}
+}
+
+// diffBoard 比较两版榜单，返回变化的条目与跌出的文档
+func diffBoard(prev, cur []BoardEntry) BoardChange {
+	old := make(map[string]BoardEntry, len(prev))
+	for _, e := range prev {
+		old[e.DocID] = e
+	}
+	var ch BoardChange
+	for _, e := range cur {
+		if o, ok := old[e.DocID]; !ok || o != e {
+			ch.Upsert = append(ch.Upsert, e)
+		}
+		delete(old, e.DocID)
+	}
+	for _, e := range prev {
+		if _, ok := old[e.DocID]; ok {
+			ch.Remove = append(ch.Remove, e.DocID)
+		}
+	}
+	return ch
+}
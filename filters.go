@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrQuarantineNotFound = errors.New("quarantined click not found")
+
+// FilterAction 为过滤器对点击的处理，数值越大越严格
+type FilterAction int
+
+const (
+	FilterPass       FilterAction = iota
+	FilterFlag                    // 计数并记录
+	FilterQuarantine              // 暂不计数，待管理员审核
+	FilterDrop                    // 直接丢弃
+)
+
+func (a FilterAction) String() string {
+	switch a {
+	case FilterFlag:
+		return "flag"
+	case FilterQuarantine:
+		return "quarantine"
+	case FilterDrop:
+		return "drop"
+	}
+	return "pass"
+}
+
+// parseFilterAction 解析配置中的处理方式
+func parseFilterAction(s string) (FilterAction, error) {
+	switch s {
+	case "flag":
+		return FilterFlag, nil
+	case "quarantine":
+		return FilterQuarantine, nil
+	case "drop":
+		return FilterDrop, nil
+	}
+	return FilterPass, fmt.Errorf("unknown filter action %q", s)
+}
+
+// ClickFilter 为点击过滤器，返回处理方式与原因
+// 过滤器可能被并发调用，有状态的实现需自行加锁
+type ClickFilter interface {
+	Name() string
+	Check(ev ClickEvent, now time.Time) (FilterAction, string)
+}
+
+// FilterPipeline 依次执行全部过滤器，取最严格的处理方式
+// 每个过滤器都会看到每次点击，以便有状态的检测器维护基线
+type FilterPipeline struct {
+	filters []ClickFilter
+}
+
+// NewFilterPipeline 按配置创建内置检测器
+func NewFilterPipeline(cfg Config) (*FilterPipeline, error) {
+	fp := &FilterPipeline{}
+	if len(cfg.FilterUADeny) > 0 {
+		act, err := parseFilterAction(cfg.FilterUAAction)
+		if err != nil {
+			return nil, err
+		}
+		fp.Use(newUAFilter(cfg.FilterUADeny, act))
+	}
+	if cfg.FilterBurstMax > 0 {
+		act, err := parseFilterAction(cfg.FilterBurstAction)
+		if err != nil {
+			return nil, err
+		}
+		fp.Use(newBurstFilter(cfg.FilterBurstMax, cfg.FilterBurstWindow, act))
+	}
+	if cfg.FilterSpikeFactor > 0 {
+		act, err := parseFilterAction(cfg.FilterSpikeAction)
+		if err != nil {
+			return nil, err
+		}
+		fp.Use(newSpikeFilter(cfg.FilterSpikeFactor, cfg.FilterSpikeMin, act))
+	}
+	return fp, nil
+}
+
+// Use 追加过滤器
+func (fp *FilterPipeline) Use(f ClickFilter) {
+	fp.filters = append(fp.filters, f)
+}
+
+// Evaluate 返回最严格的处理方式及各过滤器给出的原因
+func (fp *FilterPipeline) Evaluate(ev ClickEvent, now time.Time) (FilterAction, []string) {
+	action := FilterPass
+	var reasons []string
+	for _, f := range fp.filters {
+		act, reason := f.Check(ev, now)
+		if act == FilterPass {
+			continue
+		}
+		reasons = append(reasons, f.Name()+": "+reason)
+		action = max(action, act)
+	}
+	return action, reasons
+}
+
+// StartPruner 周期清理有状态检测器中的闲置数据
+func (fp *FilterPipeline) StartPruner(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				for _, f := range fp.filters {
+					if p, ok := f.(interface{ Prune(time.Time) }); ok {
+						p.Prune(now)
+					}
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// uaFilter 按 User-Agent 子串 (不区分大小写) 拦截爬虫
+type uaFilter struct {
+	deny   []string
+	action FilterAction
+}
+
+func newUAFilter(deny []string, action FilterAction) *uaFilter {
+	f := &uaFilter{action: action}
+	for _, d := range deny {
+		f.deny = append(f.deny, strings.ToLower(d))
+	}
+	return f
+}
+
+func (f *uaFilter) Name() string { return "user_agent" }
+
+func (f *uaFilter) Check(ev ClickEvent, _ time.Time) (FilterAction, string) {
+	ua := strings.ToLower(ev.UserAgent)
+	for _, d := range f.deny {
+		if strings.Contains(ua, d) {
+			return f.action, "matched " + d
+		}
+	}
+	return FilterPass, ""
+}
+
+// burstFilter 检测单个客户端 IP 在窗口内的点击次数是否超限
+// 不按自报的访客标识计数：客户端每次更换标识即可绕过
+type burstFilter struct {
+	max    int
+	window time.Duration
+	action FilterAction
+
+	mu   sync.Mutex
+	hits map[string][]time.Time // 客户端 IP -> 最近至多 max+1 次点击时间
+}
+
+func newBurstFilter(max int, window time.Duration, action FilterAction) *burstFilter {
+	return &burstFilter{max: max, window: window, action: action, hits: make(map[string][]time.Time)}
+}
+
+func (f *burstFilter) Name() string { return "burst" }
+
+func (f *burstFilter) Check(ev ClickEvent, now time.Time) (FilterAction, string) {
+	if ev.ClientIP == "" {
+		return FilterPass, ""
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hs := f.hits[ev.ClientIP]
+	cut := now.Add(-f.window)
+	i := 0
+	for i < len(hs) && !hs[i].After(cut) {
+		i++
+	}
+	hs = append(hs[i:], now)
+	if len(hs) > f.max+1 {
+		hs = hs[len(hs)-f.max-1:]
+	}
+	f.hits[ev.ClientIP] = hs
+	if len(hs) > f.max {
+		return f.action, fmt.Sprintf("more than %d clicks in %s", f.max, f.window)
+	}
+	return FilterPass, ""
+}
+
+// Prune 移除窗口内已无点击的客户端
+func (f *burstFilter) Prune(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cut := now.Add(-f.window)
+	for v, hs := range f.hits {
+		if len(hs) == 0 || !hs[len(hs)-1].After(cut) {
+			delete(f.hits, v)
+		}
+	}
+}
+
+// spikeAlpha 为分钟基线的指数平滑系数
+const spikeAlpha = 0.1
+
+// spikeState 为单个文档的分钟计数与基线
+type spikeState struct {
+	minute   int64
+	count    int
+	baseline float64
+}
+
+// spikeFilter 检测文档当前分钟点击数相对基线的突增
+// 基线为每分钟点击数的指数移动平均，超过 max(min, factor*基线) 视为突增
+type spikeFilter struct {
+	factor float64
+	min    int
+	action FilterAction
+
+	mu   sync.Mutex
+	docs map[string]*spikeState
+}
+
+func newSpikeFilter(factor float64, min int, action FilterAction) *spikeFilter {
+	return &spikeFilter{factor: factor, min: min, action: action, docs: make(map[string]*spikeState)}
+}
+
+func (f *spikeFilter) Name() string { return "spike" }
+
+// roll 将状态推进到 minute，中间没有点击的分钟按 0 计入基线
+func (st *spikeState) roll(minute int64) {
+	if minute <= st.minute {
+		return
+	}
+	st.baseline = spikeAlpha*float64(st.count) + (1-spikeAlpha)*st.baseline
+	if gap := minute - st.minute - 1; gap > 0 {
+		st.baseline *= math.Pow(1-spikeAlpha, float64(gap))
+	}
+	st.minute, st.count = minute, 0
+}
+
+func (f *spikeFilter) Check(ev ClickEvent, now time.Time) (FilterAction, string) {
+	minute := now.Unix() / 60
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st, ok := f.docs[ev.DocID]
+	if !ok {
+		st = &spikeState{minute: minute}
+		f.docs[ev.DocID] = st
+	}
+	st.roll(minute)
+	st.count++
+	limit := math.Max(float64(f.min), f.factor*st.baseline)
+	if float64(st.count) > limit {
+		return f.action, fmt.Sprintf("%d clicks this minute, baseline %.1f", st.count, st.baseline)
+	}
+	return FilterPass, ""
+}
+
+// Prune 移除基线已衰减到接近 0 的文档
+func (f *spikeFilter) Prune(now time.Time) {
+	minute := now.Unix() / 60
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, st := range f.docs {
+		st.roll(minute)
+		if st.count == 0 && st.baseline < 0.01 {
+			delete(f.docs, id)
+		}
+	}
+}
+
+// FilteredClick 为被隔离或标记的点击
+type FilteredClick struct {
+	ID        string      `json:"id"`
+	DocID     string      `json:"doc_id"`
+	Ts        int64       `json:"ts"`
+	Action    string      `json:"action"`
+	Reasons   []string    `json:"reasons"`
+	Source    ClickSource `json:"source"`
+	ClientIP  string      `json:"client_ip,omitempty"`
+	Visitor   string      `json:"visitor,omitempty"`
+	UserAgent string      `json:"user_agent,omitempty"`
+	Title     string      `json:"title,omitempty"`
+	URL       string      `json:"url,omitempty"`
+}
+
+// event 还原为点击事件
+func (fc FilteredClick) event() ClickEvent {
+	return ClickEvent{DocID: fc.DocID, Source: fc.Source, Title: fc.Title, URL: fc.URL, ClientIP: fc.ClientIP, Visitor: fc.Visitor, UserAgent: fc.UserAgent}
+}
+
+// Quarantine 保存待审核的点击与最近被标记的点击；由 Store 的锁保护
+// 隔离区超出上限时丢弃最早的点击，标记记录只保留最近若干条且不落盘
+type Quarantine struct {
+	items   []FilteredClick
+	max     int
+	flags   []FilteredClick
+	maxFlag int
+	next    uint64
+	stats   FilterStats
+}
+
+// FilterStats 为各处理方式的累计次数 (自启动起)
+type FilterStats struct {
+	Flagged     int64 `json:"flagged"`
+	Quarantined int64 `json:"quarantined"`
+	Dropped     int64 `json:"dropped"`
+	Approved    int64 `json:"approved"`
+	Discarded   int64 `json:"discarded"`
+}
+
+// NewQuarantine 创建隔离区
+func NewQuarantine(max, maxFlag int) *Quarantine {
+	return &Quarantine{max: max, maxFlag: maxFlag}
+}
+
+// record 按处理方式记录点击
+func (q *Quarantine) record(fc FilteredClick, action FilterAction) {
+	q.next++
+	fc.ID = strconv.FormatUint(q.next, 10)
+	fc.Action = action.String()
+	switch action {
+	case FilterDrop:
+		q.stats.Dropped++
+	case FilterQuarantine:
+		q.stats.Quarantined++
+		q.items = append(q.items, fc)
+		if q.max > 0 && len(q.items) > q.max {
+			q.items = slices.Delete(q.items, 0, len(q.items)-q.max)
+		}
+	case FilterFlag:
+		q.stats.Flagged++
+		q.flags = append(q.flags, fc)
+		if len(q.flags) > q.maxFlag {
+			q.flags = slices.Delete(q.flags, 0, len(q.flags)-q.maxFlag)
+		}
+	}
+}
+
+// get 返回隔离的点击，不移除
+func (q *Quarantine) get(id string) (FilteredClick, bool) {
+	i := slices.IndexFunc(q.items, func(fc FilteredClick) bool { return fc.ID == id })
+	if i < 0 {
+		return FilteredClick{}, false
+	}
+	return q.items[i], true
+}
+
+// take 取出并移除隔离的点击
+func (q *Quarantine) take(id string) (FilteredClick, bool) {
+	i := slices.IndexFunc(q.items, func(fc FilteredClick) bool { return fc.ID == id })
+	if i < 0 {
+		return FilteredClick{}, false
+	}
+	fc := q.items[i]
+	q.items = slices.Delete(q.items, i, i+1)
+	return fc, true
+}
+
+// restore 从落盘的隔离区恢复
+func (q *Quarantine) restore(items []FilteredClick) {
+	q.items = items
+	for _, fc := range items {
+		if n, err := strconv.ParseUint(fc.ID, 10, 64); err == nil && n > q.next {
+			q.next = n
+		}
+	}
+}
@@ -13,63 +13,52 @@ import (
 
 func main() {
 	cfg := LoadConfig()
+	if cfg.SSESlowPolicy != slowResync && cfg.SSESlowPolicy != slowDisconnect {
+		log.Fatalf("unknown SSE_SLOW_POLICY %q", cfg.SSESlowPolicy)
+	}
 
-	p, err := NewPersist(cfg.DataDir, cfg.WALSyncEveryWrite)
+	// 事件分发，多实例部署时使用 redis
+	broker, err := NewBroker(cfg)
 	if err != nil {
-		log.Fatalf("persist init error: %v", err)
+		log.Fatalf("broker init error: %v", err)
 	}
-	defer func(p *Persist) {
-		err := p.Close()
-		if err != nil {
-			log.Fatalf("persist close error: %v", err)
-		}
-	}(p)
-	log.Printf("persist ready: %s", p.DebugPaths())
 
-	// 恢复
-	state, err := p.Restore()
+	// 打开默认命名空间及已登记的命名空间 (恢复状态并启动后台任务)
+	nsm, err := OpenNamespaces(cfg, broker)
 	if err != nil {
-		log.Fatalf("restore error: %v", err)
+		log.Fatalf("namespace init error: %v", err)
 	}
-	log.Printf("restored: docs=%d counts=%d seq=%d recentClicks=%d",
-		len(state.Docs), len(state.Counts), state.Seq, len(state.RecentClicks))
 
-	// 初始化 SSE 与 Store
-	sse := NewSSEHub()
-	store := NewStore(p, sse, cfg)
-	store.Load(state)
+	// 鉴权，密钥文件变更后自动重新加载
+	auth, err := NewAuth(cfg.AuthKeysFile)
+	if err != nil {
+		log.Fatalf("auth init error: %v", err)
+	}
+	stopBg := make(chan struct{})
+	auth.StartReloader(cfg.AuthReloadInterval, stopBg)
 
-	// 启动最近窗口推进器
-	stopSnap := make(chan struct{})
-	store.StartRecentAdvancer(stopSnap)
+	// 点击令牌
+	tokens := NewClickTokens(cfg.ClickTokenSecrets, cfg.ClickTokenTTL)
+	tokens.StartPruner(stopBg)
+
+	// 限流
+	rules, err := parseRateRules(cfg.RateLimits)
+	if err != nil {
+		log.Fatalf("rate limit config error: %v", err)
+	}
+	limiter := NewRateLimiter(rules)
+	limiter.StartPruner(stopBg)
 
 	// HTTP
-	router := SetupRouter(store, sse, cfg)
+	router, err := SetupRouter(nsm, auth, tokens, limiter, cfg)
+	if err != nil {
+		log.Fatalf("trusted proxies config error: %v", err)
+	}
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
 		Handler: router,
 	}
 
-	// 周期快照
-	go func() {
-		ticker := time.NewTicker(cfg.SnapshotInterval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				counts := store.CountsSnapshot()
-				docs := store.ListDocs()
-				if err := p.SaveSnapshot(docs, counts); err != nil {
-					log.Printf("snapshot error: %v", err)
-				} else {
-					log.Printf("snapshot saved: docs=%d counts=%d", len(docs), len(counts))
-				}
-			case <-stopSnap:
-				return
-			}
-		}
-	}()
-
 	// 启动 HTTP 服务
 	go func() {
 		log.Printf("listening on :%s", cfg.Port)
@@ -83,23 +72,18 @@ func main() {
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 	fmt.Println("\nshutting down...")
+	close(stopBg)
 
-	close(stopSnap)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-
-	// 最后保存一次快照
-	counts := store.CountsSnapshot()
-	docs := store.ListDocs()
-	if err := p.SaveSnapshot(docs, counts); err != nil {
-		log.Printf("final snapshot error: %v", err)
-	}
-
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("server shutdown error: %v", err)
 	}
-	if err := p.Close(); err != nil {
-		log.Printf("persist close error: %v", err)
+
+	// 最后保存一次快照并关闭
+	nsm.Close()
+	if err := broker.Close(); err != nil {
+		log.Printf("broker close error: %v", err)
 	}
 	log.Println("bye.")
 }
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultNamespace 为原有路径使用的命名空间，数据仍存放在 DataDir 根目录
+const defaultNamespace = "default"
+
+var (
+	ErrNamespaceExists   = errors.New("namespace already exists")
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	ErrNamespaceInvalid  = errors.New("invalid namespace name")
+	ErrNamespaceLimit    = errors.New("namespace limit reached")
+	ErrNamespaceClosed   = errors.New("namespace closed")
+)
+
+var namespaceNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// NamespaceLimits 为命名空间级别的限制，0 表示不限
+type NamespaceLimits struct {
+	MaxDocs int `json:"max_docs"`
+}
+
+// NamespaceInfo 为命名空间登记信息
+type NamespaceInfo struct {
+	Name   string          `json:"name"`
+	Limits NamespaceLimits `json:"limits"`
+	Docs   int             `json:"docs"`
+}
+
+// Namespace 拥有独立的文档、榜单、WAL、快照与 SSE
+type Namespace struct {
+	Name   string
+	Limits NamespaceLimits
+	store  *Store
+	sse    *SSEHub
+	p      *Persist
+	arc    *RankArchive
+	hooks  *Webhooks
+	alerts *Alerts
+	stop   chan struct{}
+}
+
+// openNamespace 在 dir 下恢复并启动一个命名空间
+func openNamespace(name, dir string, limits NamespaceLimits, broker Broker, cfg Config) (*Namespace, error) {
+	cfg.DataDir = dir
+	cfg.MaxDocs = limits.MaxDocs
+
+	p, err := NewPersist(dir, cfg.WALSyncEveryWrite)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[%s] persist ready: %s", name, p.DebugPaths())
+
+	// 恢复
+	state, err := p.Restore()
+	if err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+	log.Printf("[%s] restored: docs=%d counts=%d seq=%d recentClicks=%d",
+		name, len(state.Docs), len(state.Counts), state.Seq, len(state.RecentClicks))
+
+	// 初始化 SSE 与 Store
+	sse := NewSSEHub(name, broker, cfg)
+	store := NewStore(p, sse, cfg)
+	store.Load(state)
+
+	// 自动登记
+	autoReg, err := NewAutoRegister(cfg)
+	if err != nil {
+		sse.Close()
+		_ = p.Close()
+		return nil, err
+	}
+	if autoReg != nil {
+		store.EnableAutoRegister(autoReg)
+	}
+
+	// 点击过滤
+	filters, err := NewFilterPipeline(cfg)
+	if err != nil {
+		sse.Close()
+		_ = p.Close()
+		return nil, err
+	}
+	store.EnableFilters(filters)
+
+	// 历史榜单归档
+	arc, err := NewRankArchive(filepath.Join(dir, "rank_archive.jsonl"), cfg.ArchiveRetention, cfg.ArchiveFullResolution)
+	if err != nil {
+		sse.Close()
+		_ = p.Close()
+		return nil, err
+	}
+
+	// 回调
+	hooks, err := NewWebhooks(name, filepath.Join(dir, "webhooks.json"), cfg)
+	if err != nil {
+		sse.Close()
+		_ = arc.Close()
+		_ = p.Close()
+		return nil, err
+	}
+	store.EnableWebhooks(hooks)
+
+	// 告警规则
+	alerts, err := NewAlerts(name, filepath.Join(dir, "alerts.json"), store, sse, cfg)
+	if err != nil {
+		_ = hooks.Close()
+		sse.Close()
+		_ = arc.Close()
+		_ = p.Close()
+		return nil, err
+	}
+
+	ns := &Namespace{Name: name, Limits: limits, store: store, sse: sse, p: p, arc: arc, hooks: hooks, alerts: alerts, stop: make(chan struct{})}
+	// 启动最近窗口推进器
+	store.StartRecentAdvancer(ns.stop)
+	// 启动榜单快照，用于名次变化
+	store.StartRankSnapshotter(ns.stop)
+	store.StartRankArchiver(arc, ns.stop)
+	filters.StartPruner(ns.stop)
+	hooks.Start(ns.stop)
+	alerts.Start(ns.stop)
+	// 周期快照
+	go func() {
+		ticker := time.NewTicker(cfg.SnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ns.saveSnapshot()
+			case <-ns.stop:
+				return
+			}
+		}
+	}()
+	return ns, nil
+}
+
+// saveSnapshot 保存时间序列、快照与隔离区
+// 时间序列先于快照保存，快照轮转 WAL 时据其序号保留尚未计入时间序列的点击
+func (ns *Namespace) saveSnapshot() {
+	b, seq, err := ns.store.TimeSeriesSnapshot()
+	if err == nil {
+		err = ns.p.SaveTimeSeries(b, seq)
+	}
+	if err != nil {
+		log.Printf("[%s] timeseries save error: %v", ns.Name, err)
+	}
+	counts, sketch := ns.store.CountsSnapshot()
+	docs := ns.store.ListDocs()
+	if err := ns.p.SaveSnapshot(docs, counts, sketch, ns.store.SourcesSnapshot()); err != nil {
+		log.Printf("[%s] snapshot error: %v", ns.Name, err)
+	} else {
+		log.Printf("[%s] snapshot saved: docs=%d counts=%d", ns.Name, len(docs), len(counts))
+	}
+	b, err = ns.store.QuarantineSnapshot()
+	if err == nil {
+		err = ns.p.SaveQuarantine(b)
+	}
+	if err != nil {
+		log.Printf("[%s] quarantine save error: %v", ns.Name, err)
+	}
+}
+
+// Close 停止后台任务，保存最后一次快照并关闭文件
+func (ns *Namespace) Close() {
+	close(ns.stop)
+	ns.store.StopPush()
+	ns.sse.Close()
+	ns.saveSnapshot()
+	if err := ns.hooks.Close(); err != nil {
+		log.Printf("[%s] webhooks close error: %v", ns.Name, err)
+	}
+	if err := ns.alerts.Close(); err != nil {
+		log.Printf("[%s] alerts close error: %v", ns.Name, err)
+	}
+	if err := ns.arc.Close(); err != nil {
+		log.Printf("[%s] archive close error: %v", ns.Name, err)
+	}
+	if err := ns.p.Close(); err != nil {
+		log.Printf("[%s] persist close error: %v", ns.Name, err)
+	}
+}
+
+// Namespaces 管理全部命名空间，登记表保存在 DataDir/namespaces.json
+type Namespaces struct {
+	mu      sync.RWMutex
+	cfg     Config
+	broker  Broker
+	regPath string
+	def     *Namespace
+	m       map[string]*Namespace
+}
+
+// OpenNamespaces 打开默认命名空间及登记表中的全部命名空间，各命名空间的事件经 broker 分发
+func OpenNamespaces(cfg Config, broker Broker) (*Namespaces, error) {
+	def, err := openNamespace(defaultNamespace, cfg.DataDir, NamespaceLimits{MaxDocs: cfg.MaxDocs}, broker, cfg)
+	if err != nil {
+		return nil, err
+	}
+	nsm := &Namespaces{
+		cfg:     cfg,
+		broker:  broker,
+		regPath: filepath.Join(cfg.DataDir, "namespaces.json"),
+		def:     def,
+		m:       make(map[string]*Namespace),
+	}
+	var reg []NamespaceInfo
+	if b, err := os.ReadFile(nsm.regPath); err == nil {
+		if err := json.Unmarshal(b, &reg); err != nil {
+			return nil, err
+		}
+	}
+	for _, info := range reg {
+		ns, err := openNamespace(info.Name, nsm.dir(info.Name), info.Limits, broker, cfg)
+		if err != nil {
+			return nil, err
+		}
+		nsm.m[info.Name] = ns
+	}
+	return nsm, nil
+}
+
+func (nsm *Namespaces) dir(name string) string {
+	return filepath.Join(nsm.cfg.DataDir, "ns", name)
+}
+
+// saveRegistryLocked 写入命名空间登记表
+func (nsm *Namespaces) saveRegistryLocked() error {
+	reg := make([]NamespaceInfo, 0, len(nsm.m))
+	for _, ns := range nsm.m {
+		reg = append(reg, NamespaceInfo{Name: ns.Name, Limits: ns.Limits})
+	}
+	sort.Slice(reg, func(i, j int) bool { return reg[i].Name < reg[j].Name })
+	b, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(nsm.regPath, b)
+}
+
+// Get 返回命名空间
+func (nsm *Namespaces) Get(name string) (*Namespace, bool) {
+	if name == defaultNamespace {
+		return nsm.def, true
+	}
+	nsm.mu.RLock()
+	defer nsm.mu.RUnlock()
+	ns, ok := nsm.m[name]
+	return ns, ok
+}
+
+// List 返回全部命名空间，默认命名空间在前
+func (nsm *Namespaces) List() []NamespaceInfo {
+	nsm.mu.RLock()
+	defer nsm.mu.RUnlock()
+	out := []NamespaceInfo{{Name: nsm.def.Name, Limits: nsm.def.Limits, Docs: nsm.def.store.DocCount()}}
+	names := make([]string, 0, len(nsm.m))
+	for name := range nsm.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ns := nsm.m[name]
+		out = append(out, NamespaceInfo{Name: name, Limits: ns.Limits, Docs: ns.store.DocCount()})
+	}
+	return out
+}
+
+// Create 创建并启动命名空间
+func (nsm *Namespaces) Create(name string, limits NamespaceLimits) error {
+	if !namespaceNameRe.MatchString(name) || name == defaultNamespace {
+		return ErrNamespaceInvalid
+	}
+	nsm.mu.Lock()
+	defer nsm.mu.Unlock()
+	if _, ok := nsm.m[name]; ok {
+		return ErrNamespaceExists
+	}
+	if nsm.cfg.MaxNamespaces > 0 && len(nsm.m) >= nsm.cfg.MaxNamespaces {
+		return ErrNamespaceLimit
+	}
+	ns, err := openNamespace(name, nsm.dir(name), limits, nsm.broker, nsm.cfg)
+	if err != nil {
+		return err
+	}
+	nsm.m[name] = ns
+	if err := nsm.saveRegistryLocked(); err != nil {
+		delete(nsm.m, name)
+		ns.Close()
+		return err
+	}
+	return nil
+}
+
+// Delete 停止命名空间并删除其全部数据
+// 先在锁内从登记中移除，关闭与删除目录在锁外进行，不阻塞其他命名空间的查找；
+// 关闭时断开该命名空间的 SSE/WebSocket 连接，已取得该命名空间的请求写入时得到 ErrNamespaceClosed
+func (nsm *Namespaces) Delete(name string) error {
+	nsm.mu.Lock()
+	ns, ok := nsm.m[name]
+	if !ok {
+		nsm.mu.Unlock()
+		return ErrNamespaceNotFound
+	}
+	delete(nsm.m, name)
+	if err := nsm.saveRegistryLocked(); err != nil {
+		nsm.m[name] = ns
+		nsm.mu.Unlock()
+		return err
+	}
+	nsm.mu.Unlock()
+	ns.Close()
+	return os.RemoveAll(nsm.dir(name))
+}
+
+// Close 关闭全部命名空间
+func (nsm *Namespaces) Close() {
+	nsm.mu.Lock()
+	defer nsm.mu.Unlock()
+	for _, ns := range nsm.m {
+		ns.Close()
+	}
+	nsm.def.Close()
+}
+
+// UseDefault 返回将默认命名空间放入上下文的中间件
+func (nsm *Namespaces) UseDefault() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("ns", nsm.def)
+		c.Next()
+	}
+}
+
+// UseParam 返回按路径参数 :ns 解析命名空间的中间件
+func (nsm *Namespaces) UseParam() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ns, ok := nsm.Get(c.Param("ns"))
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"code": 404, "message": ErrNamespaceNotFound.Error()})
+			return
+		}
+		c.Set("ns", ns)
+		c.Next()
+	}
+}
+
+// nsOf 返回请求所属的命名空间
+func nsOf(c *gin.Context) *Namespace {
+	return c.MustGet("ns").(*Namespace)
+}
+
+// storeOf 返回请求所属命名空间的 Store
+func storeOf(c *gin.Context) *Store {
+	return nsOf(c).store
+}
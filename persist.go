@@ -16,17 +16,25 @@ import (
 type Persist struct {
 	walPath      string
 	snapPath     string
+	tsPath       string
+	quarPath     string
 	mu           sync.Mutex
 	walFile      *os.File
 	walBufWriter *bufio.Writer
 	seq          uint64
 	syncEvery    bool
+	closed       bool
+	// 已落盘的时间序列覆盖到的 WAL 序号，轮转 WAL 时保留其后的点击供恢复时补齐
+	seriesSeq uint64
 }
 
 type snapshotModel struct {
-	Docs   []Doc          `json:"docs"`
-	Counts map[string]int `json:"counts"`
-	Seq    uint64         `json:"seq"`
+	Docs    []Doc                                `json:"docs"`
+	Counts  map[string]int                       `json:"counts"`
+	Seq     uint64                               `json:"seq"`
+	Sources map[string]map[string]map[string]int `json:"sources,omitempty"`
+	// 总榜为近似结构时的完整状态
+	Sketch *spaceSavingModel `json:"sketch,omitempty"`
 }
 
 // NewPersist 创建持久化管理器
@@ -37,6 +45,8 @@ func NewPersist(dir string, syncEveryWrite bool) (*Persist, error) {
 	p := &Persist{
 		walPath:   filepath.Join(dir, "wal.jsonl"),
 		snapPath:  filepath.Join(dir, "snapshot.json"),
+		tsPath:    filepath.Join(dir, "timeseries.json"),
+		quarPath:  filepath.Join(dir, "quarantine.json"),
 		syncEvery: syncEveryWrite,
 	}
 	if err := p.openWAL(); err != nil {
@@ -57,23 +67,37 @@ func (p *Persist) openWAL() error {
 
 // AppendWAL 追加一条 WAL 记录 (线程安全)
 func (p *Persist) AppendWAL(e walEntry) error {
+	return p.AppendWALBatch([]walEntry{e})
+}
+
+// AppendWALBatch 追加一批 WAL 记录，整批只刷盘一次 (线程安全)
+func (p *Persist) AppendWALBatch(entries []walEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// 维护 seq
-	if e.Seq == 0 {
-		p.seq++
-		e.Seq = p.seq
-	} else if e.Seq > p.seq {
-		p.seq = e.Seq
-	}
-	b, err := json.Marshal(e)
-	if err != nil {
-		return err
+	if p.closed {
+		return ErrNamespaceClosed
 	}
-	if _, err := p.walBufWriter.Write(b); err != nil {
-		return err
+	// 先整体编码，避免写入半批
+	buf := make([]byte, 0, 128*len(entries))
+	for _, e := range entries {
+		// 维护 seq
+		if e.Seq == 0 {
+			p.seq++
+			e.Seq = p.seq
+		} else if e.Seq > p.seq {
+			p.seq = e.Seq
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
 	}
-	if err := p.walBufWriter.WriteByte('\n'); err != nil {
+	if _, err := p.walBufWriter.Write(buf); err != nil {
 		return err
 	}
 	if p.syncEvery {
@@ -91,30 +115,41 @@ func (p *Persist) AppendWAL(e walEntry) error {
 func (p *Persist) Flush() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return ErrNamespaceClosed
+	}
 	if err := p.walBufWriter.Flush(); err != nil {
 		return err
 	}
 	return p.walFile.Sync()
 }
 
-// Close 刷新并关闭 WAL
+// Close 刷新并关闭 WAL，之后的写入返回 ErrNamespaceClosed
 func (p *Persist) Close() error {
 	if err := p.Flush(); err != nil {
 		return err
 	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
 	return p.walFile.Close()
 }
 
-// SaveSnapshot 写入快照并轮转 WAL 保留最近 600 秒点击
-func (p *Persist) SaveSnapshot(docs []Doc, counts map[string]int) error {
+// SaveSnapshot 写入快照并轮转 WAL 保留最近 600 秒点击，以及时间序列尚未包含的点击
+func (p *Persist) SaveSnapshot(docs []Doc, counts map[string]int, sketch *spaceSavingModel, sources map[string]map[string]map[string]int) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return ErrNamespaceClosed
+	}
 
 	// 写快照
 	model := snapshotModel{
-		Docs:   docs,
-		Counts: counts,
-		Seq:    p.seq,
+		Docs:    docs,
+		Counts:  counts,
+		Seq:     p.seq,
+		Sources: sources,
+		Sketch:  sketch,
 	}
 	tmp := p.snapPath + ".tmp"
 	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
@@ -138,7 +173,7 @@ func (p *Persist) SaveSnapshot(docs []Doc, counts map[string]int) error {
 		return err
 	}
 
-	// 轮转 WAL，只保留最近 600 秒 CLICK
+	// 轮转 WAL，只保留最近 600 秒与序号在 seriesSeq 之后的 CLICK
 	cutoff := time.Now().Add(-600 * time.Second).Unix()
 
 	// 关闭旧 writer 和 file
@@ -162,7 +197,7 @@ func (p *Persist) SaveSnapshot(docs []Doc, counts map[string]int) error {
 	}
 	newWriter := bufio.NewWriterSize(newFile, 1<<20)
 
-	// 从 old 复制近 600 秒内的 CLICK
+	// 从 old 复制需保留的 CLICK
 	if fOld, err := os.Open(oldPath); err == nil {
 		defer func(fOld *os.File) {
 			err := fOld.Close()
@@ -176,7 +211,7 @@ func (p *Persist) SaveSnapshot(docs []Doc, counts map[string]int) error {
 			if len(line) > 0 {
 				var e walEntry
 				if json.Unmarshal(line, &e) == nil {
-					if e.Op == "CLICK" && e.Ts >= cutoff {
+					if e.Op == "CLICK" && (e.Ts >= cutoff || e.Seq > p.seriesSeq) {
 						if _, err := newWriter.Write(line); err != nil {
 							return err
 						}
@@ -211,9 +246,14 @@ type RestoreState struct {
 	Counts       map[string]int
 	Seq          uint64
 	RecentClicks []walEntry
+	Series       *timeSeriesModel
+	SeriesClicks []walEntry // 序号在 Series.Seq 之后的全部点击，不受最近窗口限制
+	Sources      map[string]map[string]map[string]int
+	Sketch       *spaceSavingModel
+	Quarantine   []FilteredClick
 }
 
-// Restore 读取快照并回放 WAL 最近 600 秒点击
+// Restore 读取快照并回放 WAL：最近 600 秒点击用于最近榜，时间序列保存之后的点击用于补齐时间序列
 func (p *Persist) Restore() (*RestoreState, error) {
 	state := &RestoreState{
 		Counts:       make(map[string]int, 1024),
@@ -232,6 +272,24 @@ func (p *Persist) Restore() (*RestoreState, error) {
 			state.Docs = snap.Docs
 			state.Counts = snap.Counts
 			state.Seq = snap.Seq
+			state.Sources = snap.Sources
+			state.Sketch = snap.Sketch
+		}
+	}
+	// 读时间序列
+	if b, err := os.ReadFile(p.tsPath); err == nil {
+		var m timeSeriesModel
+		if err := json.Unmarshal(b, &m); err == nil {
+			state.Series = &m
+			p.seriesSeq = m.Seq
+		} else {
+			log.Printf("timeseries decode error: %v", err)
+		}
+	}
+	// 读隔离区
+	if b, err := os.ReadFile(p.quarPath); err == nil {
+		if err := json.Unmarshal(b, &state.Quarantine); err != nil {
+			log.Printf("quarantine decode error: %v", err)
 		}
 	}
 	// 读 WAL (仅保留近 600 秒 CLICK)
@@ -264,6 +322,9 @@ func (p *Persist) Restore() (*RestoreState, error) {
 					if e.Ts >= cutoff && e.Ts <= nowSec {
 						state.RecentClicks = append(state.RecentClicks, e)
 					}
+					if e.ID != "" && e.Ts > 0 && e.Seq > p.seriesSeq {
+						state.SeriesClicks = append(state.SeriesClicks, e)
+					}
 				}
 				if e.Seq > state.Seq {
 					state.Seq = e.Seq
@@ -281,6 +342,50 @@ func (p *Persist) Restore() (*RestoreState, error) {
 	return state, nil
 }
 
+// SaveTimeSeries 原子写入时间序列文件，seq 为其覆盖到的 WAL 序号
+func (p *Persist) SaveTimeSeries(b []byte, seq uint64) error {
+	if err := writeFileAtomic(p.tsPath, b); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seriesSeq = max(p.seriesSeq, seq)
+	return nil
+}
+
+// SaveQuarantine 原子写入隔离区文件
+func (p *Persist) SaveQuarantine(b []byte) error {
+	return writeFileAtomic(p.quarPath, b)
+}
+
+// Seq 返回当前最大序号
+func (p *Persist) Seq() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seq
+}
+
+// writeFileAtomic 写临时文件并落盘后替换目标文件
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 // NextSeq 返回下一个序号
 func (p *Persist) NextSeq() uint64 {
 	p.mu.Lock()
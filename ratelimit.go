@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 限流键
+const (
+	limitByIP      = "ip"
+	limitByAPIKey  = "apikey"  // 未鉴权时退化为 IP
+	limitByVisitor = "visitor" // X-Visitor-ID 头或 vid Cookie，缺失时退化为 IP；由客户端自报，仅起约束作用
+)
+
+// rateLimitRoutes 为可配置限流的路由
+var rateLimitRoutes = []string{"click", "go"}
+
+// 超限处理方式
+const (
+	limitReject    = "reject"    // 返回 429
+	limitUncounted = "uncounted" // 正常响应但不计入点击
+)
+
+// RateRule 为单个路由的限流规则
+type RateRule struct {
+	Route  string  `json:"route"`
+	Key    string  `json:"key"`
+	Rate   float64 `json:"rate"` // 每秒补充的令牌数
+	Burst  int     `json:"burst"`
+	Action string  `json:"action"`
+}
+
+// parseRateRules 解析 RATE_LIMITS，每项格式为 路由:键:速率:突发[:处理方式]
+// 速率形如 10/s、600/m，例如 click:ip:10/s:20:reject,go:visitor:1/s:5:uncounted
+func parseRateRules(items []string) ([]RateRule, error) {
+	rules := make([]RateRule, 0, len(items))
+	for _, item := range items {
+		parts := strings.Split(item, ":")
+		if len(parts) != 4 && len(parts) != 5 {
+			return nil, fmt.Errorf("rate limit %q: want route:key:rate:burst[:action]", item)
+		}
+		rule := RateRule{Route: parts[0], Key: parts[1], Action: limitReject}
+		if !slices.Contains(rateLimitRoutes, rule.Route) {
+			return nil, fmt.Errorf("rate limit %q: unknown route %q", item, rule.Route)
+		}
+		switch rule.Key {
+		case limitByIP, limitByAPIKey, limitByVisitor:
+		default:
+			return nil, fmt.Errorf("rate limit %q: unknown key %q", item, rule.Key)
+		}
+		n, per, _ := strings.Cut(parts[2], "/")
+		rate, err := strconv.ParseFloat(n, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("rate limit %q: bad rate", item)
+		}
+		switch per {
+		case "", "s":
+		case "m":
+			rate /= 60
+		case "h":
+			rate /= 3600
+		default:
+			return nil, fmt.Errorf("rate limit %q: bad rate unit", item)
+		}
+		rule.Rate = rate
+		if rule.Burst, err = strconv.Atoi(parts[3]); err != nil || rule.Burst <= 0 {
+			return nil, fmt.Errorf("rate limit %q: bad burst", item)
+		}
+		if len(parts) == 5 {
+			rule.Action = parts[4]
+		}
+		if rule.Action != limitReject && rule.Action != limitUncounted {
+			return nil, fmt.Errorf("rate limit %q: unknown action %q", item, rule.Action)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// tokenBucket 为单个客户端的令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateStats 为路由的限流统计
+type RateStats struct {
+	RateRule
+	Clients   int   `json:"clients"`
+	Allowed   int64 `json:"allowed"`
+	Rejected  int64 `json:"rejected"`
+	Uncounted int64 `json:"uncounted"`
+}
+
+// routeLimiter 按客户端维护令牌桶
+type routeLimiter struct {
+	mu      sync.Mutex
+	rule    RateRule
+	buckets map[string]*tokenBucket
+	stats   RateStats
+}
+
+// take 消耗一个令牌；不足时返回需要等待的时长
+func (l *routeLimiter) take(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.rule.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.rule.Burst), b.tokens+now.Sub(b.last).Seconds()*l.rule.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		l.stats.Allowed++
+		return true, 0
+	}
+	if l.rule.Action == limitReject {
+		l.stats.Rejected++
+	} else {
+		l.stats.Uncounted++
+	}
+	return false, time.Duration((1 - b.tokens) / l.rule.Rate * float64(time.Second))
+}
+
+// prune 移除已回满的令牌桶，它们与新建的桶等价
+func (l *routeLimiter) prune(now time.Time) {
+	full := time.Duration(float64(l.rule.Burst) / l.rule.Rate * float64(time.Second))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// RateLimiter 为各路由的令牌桶限流，限流跨命名空间按客户端计算
+type RateLimiter struct {
+	routes map[string]*routeLimiter
+}
+
+// NewRateLimiter 按规则创建限流器
+func NewRateLimiter(rules []RateRule) *RateLimiter {
+	rl := &RateLimiter{routes: make(map[string]*routeLimiter, len(rules))}
+	for _, rule := range rules {
+		rl.routes[rule.Route] = &routeLimiter{
+			rule:    rule,
+			buckets: make(map[string]*tokenBucket),
+			stats:   RateStats{RateRule: rule},
+		}
+	}
+	return rl
+}
+
+// clientKey 按规则取客户端标识
+func clientKey(c *gin.Context, key string) string {
+	switch key {
+	case limitByAPIKey:
+		if v, ok := c.Get("apikey"); ok {
+			return "key:" + v.(APIKey).Name
+		}
+	case limitByVisitor:
+		if v := c.GetHeader("X-Visitor-ID"); v != "" {
+			return "vid:" + v
+		}
+		if v, err := c.Cookie("vid"); err == nil && v != "" {
+			return "vid:" + v
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// Check 为一次请求消耗路由的令牌，返回超限时的处理方式 (放行时为空) 与需等待的时长
+// 供无法使用中间件的场景，如 WebSocket 连接内的点击
+func (rl *RateLimiter) Check(route string, c *gin.Context) (string, time.Duration) {
+	l, ok := rl.routes[route]
+	if !ok {
+		return "", 0
+	}
+	if allowed, wait := l.take(clientKey(c, l.rule.Key), time.Now()); !allowed {
+		return l.rule.Action, wait
+	}
+	return "", 0
+}
+
+// retryAfter 将等待时长转换为 Retry-After 秒数
+func retryAfter(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// Limit 返回路由的限流中间件，未配置规则时直接放行
+// 超限时按规则返回 429，或在上下文中标记为不计数
+func (rl *RateLimiter) Limit(route string) gin.HandlerFunc {
+	if _, ok := rl.routes[route]; !ok {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		switch action, wait := rl.Check(route, c); action {
+		case limitReject:
+			c.Header("Retry-After", strconv.Itoa(retryAfter(wait)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"code": 429, "message": "rate limit exceeded"})
+			return
+		case limitUncounted:
+			c.Set("uncounted", true)
+		}
+		c.Next()
+	}
+}
+
+// uncounted 判断请求是否被限流为不计数
+func uncounted(c *gin.Context) bool {
+	return c.GetBool("uncounted")
+}
+
+// Stats 返回各路由的限流统计
+func (rl *RateLimiter) Stats() []RateStats {
+	out := make([]RateStats, 0, len(rl.routes))
+	for _, l := range rl.routes {
+		l.mu.Lock()
+		st := l.stats
+		st.Clients = len(l.buckets)
+		l.mu.Unlock()
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out
+}
+
+// StartPruner 周期清理闲置的令牌桶
+func (rl *RateLimiter) StartPruner(stop <-chan struct{}) {
+	if len(rl.routes) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				for _, l := range rl.routes {
+					l.prune(now)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -5,14 +5,14 @@ import "time"
 type Recent struct {
 	ring        [600]map[string]int
 	lastUnixSec int64
-	bkt         *Buckets
+	bkt         rankBoard
 }
 
-// NewRecent 创建最近窗口结构
-func NewRecent() *Recent {
+// NewRecent 创建最近窗口结构，bkt 为窗口内计数所用的榜单结构
+func NewRecent(bkt rankBoard) *Recent {
 	r := &Recent{
 		lastUnixSec: time.Now().Unix(),
-		bkt:         NewBuckets(),
+		bkt:         bkt,
 	}
 	for i := 0; i < 600; i++ {
 		r.ring[i] = make(map[string]int)
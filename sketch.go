@@ -0,0 +1,292 @@
+package main
+
+import (
+	"hash/fnv"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// rankBoard 为榜单计数结构的公共接口，精确模式为 Buckets，近似模式为 SpaceSaving
+type rankBoard interface {
+	Add(id string)
+	Adjust(id string, delta int) int
+	Delete(id string)
+	TopK(k int) []RankItem
+	GetCount(id string) int
+	Len() int
+	Counts() map[string]int
+	ResetFromCounts(counts map[string]int)
+}
+
+// ApproxInfo 描述近似榜单的误差界
+type ApproxInfo struct {
+	Algorithm string `json:"algorithm"`
+	Capacity  int    `json:"capacity"`
+	Monitored int    `json:"monitored"`
+	Total     int    `json:"total"` // 计入的点击总数 N
+	// 任一条目计数的最大高估量：已满时为最小计数，否则为 0
+	MaxError int `json:"max_error"`
+	// Count-Min 点查询误差：以 1-delta 的概率高估不超过 epsilon*N
+	CMSEpsilon    float64 `json:"cms_epsilon"`
+	CMSDelta      float64 `json:"cms_delta"`
+	CMSErrorBound int     `json:"cms_error_bound"`
+}
+
+// approxBoard 由近似榜单实现，用于在响应中报告误差
+type approxBoard interface {
+	ApproxInfo() ApproxInfo
+	ItemError(id string) int
+}
+
+// CountMin 为 Count-Min Sketch，支持增减 (turnstile) 与点查询
+type CountMin struct {
+	width, depth int
+	rows         [][]int
+	eps, delta   float64
+}
+
+// NewCountMin 按误差 epsilon 与失败概率 delta 创建 Count-Min Sketch
+func NewCountMin(eps, delta float64) *CountMin {
+	w := int(math.Ceil(math.E / eps))
+	d := int(math.Ceil(math.Log(1 / delta)))
+	cm := &CountMin{width: w, depth: d, rows: make([][]int, d), eps: eps, delta: delta}
+	for i := range cm.rows {
+		cm.rows[i] = make([]int, w)
+	}
+	return cm
+}
+
+func (cm *CountMin) index(row int, id string) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte{byte(row)})
+	_, _ = h.Write([]byte(id))
+	return int(h.Sum64() % uint64(cm.width))
+}
+
+// Add 调整 id 的计数
+func (cm *CountMin) Add(id string, delta int) {
+	for i := range cm.rows {
+		cm.rows[i][cm.index(i, id)] += delta
+	}
+}
+
+// Estimate 返回 id 的计数估计 (只会高估)
+func (cm *CountMin) Estimate(id string) int {
+	est := math.MaxInt
+	for i := range cm.rows {
+		est = min(est, cm.rows[i][cm.index(i, id)])
+	}
+	return max(est, 0)
+}
+
+// Reset 清空计数
+func (cm *CountMin) Reset() {
+	for i := range cm.rows {
+		clear(cm.rows[i])
+	}
+}
+
+// SpaceSaving 以固定容量追踪高频条目，底层复用 Buckets 维护计数顺序
+// 新条目在容量已满时替换最小计数的条目，并继承其计数作为误差；
+// 减量只作用于已追踪的条目，扣至只剩继承误差时释放；未追踪条目的计数由 Count-Min 估计
+type SpaceSaving struct {
+	b        *Buckets
+	errs     map[string]int
+	capacity int
+	total    int
+	cms      *CountMin
+}
+
+// NewSpaceSaving 创建近似榜单
+func NewSpaceSaving(capacity int, eps, delta float64) *SpaceSaving {
+	return &SpaceSaving{
+		b:        NewBuckets(),
+		errs:     make(map[string]int, capacity),
+		capacity: capacity,
+		cms:      NewCountMin(eps, delta),
+	}
+}
+
+// Add 近似模式下只统计点击，不预先登记条目
+func (ss *SpaceSaving) Add(string) {}
+
+// minEntry 返回计数最小的已追踪条目
+func (ss *SpaceSaving) minEntry() *entry {
+	for bb := ss.b.zeroB; bb != nil; bb = bb.next {
+		if bb.size > 0 {
+			// 桶尾是最早进入该计数的条目
+			return bb.tail
+		}
+	}
+	return nil
+}
+
+// Adjust 调整 id 的计数并返回估计值
+func (ss *SpaceSaving) Adjust(id string, delta int) int {
+	if delta == 0 {
+		return ss.GetCount(id)
+	}
+	ss.total = max(ss.total+delta, 0)
+	ss.cms.Add(id, delta)
+	if ss.b.has(id) {
+		n := ss.b.Adjust(id, delta)
+		if n <= ss.errs[id] {
+			// 实际计入的点击已全部扣除，剩余只是继承的误差 (如最近窗口过期)，释放位置，
+			// 否则继承的计数永远不会过期
+			ss.b.Delete(id)
+			delete(ss.errs, id)
+			return 0
+		}
+		return n
+	}
+	if delta < 0 {
+		return 0
+	}
+	if len(ss.b.entries) < ss.capacity {
+		return ss.b.Adjust(id, delta)
+	}
+	victim := ss.minEntry()
+	if victim == nil {
+		return ss.b.Adjust(id, delta)
+	}
+	floor := victim.count
+	ss.b.Delete(victim.id)
+	delete(ss.errs, victim.id)
+	ss.errs[id] = floor
+	return ss.b.Adjust(id, floor+delta)
+}
+
+// Delete 移除 id
+func (ss *SpaceSaving) Delete(id string) {
+	if n := ss.b.GetCount(id); n > 0 {
+		ss.total = max(ss.total-n, 0)
+		ss.cms.Add(id, -n)
+	}
+	ss.b.Delete(id)
+	delete(ss.errs, id)
+}
+
+// TopK 返回前 K 项估计
+func (ss *SpaceSaving) TopK(k int) []RankItem {
+	return ss.b.TopK(k)
+}
+
+// GetCount 已追踪条目返回其计数，否则返回 Count-Min 估计 (不超过当前最小计数)
+func (ss *SpaceSaving) GetCount(id string) int {
+	if ss.b.has(id) {
+		return ss.b.GetCount(id)
+	}
+	est := ss.cms.Estimate(id)
+	if len(ss.b.entries) >= ss.capacity {
+		if e := ss.minEntry(); e != nil {
+			est = min(est, e.count)
+		}
+	}
+	return est
+}
+
+// Len 返回已追踪条目数
+func (ss *SpaceSaving) Len() int {
+	return ss.b.Len()
+}
+
+// Counts 返回已追踪条目的计数
+func (ss *SpaceSaving) Counts() map[string]int {
+	return ss.b.Counts()
+}
+
+// ResetFromCounts 用计数快照重建，只保留计数最高的 capacity 项
+func (ss *SpaceSaving) ResetFromCounts(counts map[string]int) {
+	type pair struct {
+		id string
+		c  int
+	}
+	arr := make([]pair, 0, len(counts))
+	ss.total = 0
+	ss.cms.Reset()
+	for id, c := range counts {
+		if c <= 0 {
+			continue
+		}
+		arr = append(arr, pair{id: id, c: c})
+		ss.total += c
+		ss.cms.Add(id, c)
+	}
+	slices.SortFunc(arr, func(p, q pair) int {
+		if p.c != q.c {
+			return q.c - p.c
+		}
+		return strings.Compare(p.id, q.id)
+	})
+	kept := make(map[string]int, min(len(arr), ss.capacity))
+	for _, p := range arr[:min(len(arr), ss.capacity)] {
+		kept[p.id] = p.c
+	}
+	ss.b.ResetFromCounts(kept)
+	clear(ss.errs)
+}
+
+// spaceSavingModel 为近似榜单的持久化格式：已追踪条目及其继承误差、点击总数与 Count-Min 计数表
+type spaceSavingModel struct {
+	Counts map[string]int `json:"counts"`
+	Errs   map[string]int `json:"errs,omitempty"`
+	Total  int            `json:"total"`
+	Rows   [][]int        `json:"rows"`
+}
+
+// model 复制当前状态用于持久化
+func (ss *SpaceSaving) model() *spaceSavingModel {
+	m := &spaceSavingModel{Counts: ss.b.Counts(), Errs: maps.Clone(ss.errs), Total: ss.total, Rows: make([][]int, len(ss.cms.rows))}
+	for i, r := range ss.cms.rows {
+		m.Rows[i] = slices.Clone(r)
+	}
+	return m
+}
+
+// restore 从持久化状态恢复；容量或 Count-Min 参数与保存时不同则返回 false，由调用方按计数重建
+func (ss *SpaceSaving) restore(m *spaceSavingModel) bool {
+	if len(m.Counts) > ss.capacity || len(m.Rows) != ss.cms.depth {
+		return false
+	}
+	for _, r := range m.Rows {
+		if len(r) != ss.cms.width {
+			return false
+		}
+	}
+	for i, r := range m.Rows {
+		copy(ss.cms.rows[i], r)
+	}
+	ss.b.ResetFromCounts(m.Counts)
+	ss.errs = maps.Clone(m.Errs)
+	if ss.errs == nil {
+		ss.errs = make(map[string]int, ss.capacity)
+	}
+	ss.total = m.Total
+	return true
+}
+
+// ApproxInfo 返回当前误差界
+func (ss *SpaceSaving) ApproxInfo() ApproxInfo {
+	info := ApproxInfo{
+		Algorithm:     "space-saving",
+		Capacity:      ss.capacity,
+		Monitored:     len(ss.b.entries),
+		Total:         ss.total,
+		CMSEpsilon:    ss.cms.eps,
+		CMSDelta:      ss.cms.delta,
+		CMSErrorBound: int(math.Ceil(ss.cms.eps * float64(ss.total))),
+	}
+	if info.Monitored >= ss.capacity {
+		if e := ss.minEntry(); e != nil {
+			info.MaxError = e.count
+		}
+	}
+	return info
+}
+
+// ItemError 返回条目计数的最大高估量
+func (ss *SpaceSaving) ItemError(id string) int {
+	return ss.errs[id]
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestSpaceSavingAdjust(t *testing.T) {
+	tests := []struct {
+		name   string
+		ops    []bucketOp
+		last   int            // 最后一次 Adjust 的返回值
+		want   []string       // TopK(3)
+		errs   map[string]int // ItemError
+		counts map[string]int // GetCount，含未追踪条目的估计
+	}{
+		{
+			name: "below capacity is exact",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 2}},
+			last: 2, want: []string{"b:2", "a:1"},
+			errs: map[string]int{"a": 0, "b": 0},
+		},
+		{
+			name: "new id replaces the minimum and inherits its count",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 3}, {id: "c", delta: 1}},
+			last: 2, want: []string{"b:3", "c:2"},
+			errs:   map[string]int{"c": 1, "b": 0},
+			counts: map[string]int{"a": 1, "c": 2},
+		},
+		{
+			name: "ties evict the oldest entry at the minimum",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 1}, {id: "c", delta: 1}},
+			last: 2, want: []string{"c:2", "b:1"},
+			errs:   map[string]int{"c": 1},
+			counts: map[string]int{"a": 1},
+		},
+		{
+			name: "larger delta on replacement",
+			ops:  []bucketOp{{id: "a", delta: 2}, {id: "b", delta: 5}, {id: "c", delta: 4}},
+			last: 6, want: []string{"c:6", "b:5"},
+			errs: map[string]int{"c": 2},
+		},
+		{
+			name: "decrement down to the inherited error frees the slot",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 2}, {id: "c", delta: 1}, {id: "c", delta: -1}},
+			last: 0, want: []string{"b:2"},
+			errs: map[string]int{"c": 0},
+		},
+		{
+			name: "decrement keeps counted clicks above the error",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 2}, {id: "c", delta: 3}, {id: "c", delta: -1}},
+			last: 3, want: []string{"c:3", "b:2"},
+			errs: map[string]int{"c": 1},
+		},
+		{
+			name: "decrement of an untracked id is ignored",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "z", delta: -1}},
+			last: 0, want: []string{"a:1"},
+		},
+		{
+			name: "delete releases a slot for the next id",
+			ops:  []bucketOp{{id: "a", delta: 1}, {id: "b", delta: 2}, {id: "b", del: true}, {id: "c", delta: 1}},
+			last: 1, want: []string{"c:1", "a:1"},
+			errs: map[string]int{"c": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := NewSpaceSaving(2, 0.001, 0.01)
+			last := 0
+			for _, op := range tt.ops {
+				switch {
+				case op.del:
+					ss.Delete(op.id)
+				default:
+					last = ss.Adjust(op.id, op.delta)
+				}
+			}
+			if last != tt.last {
+				t.Errorf("last Adjust = %d, want %d", last, tt.last)
+			}
+			if got := rankString(ss.TopK(3)); !slices.Equal(got, tt.want) {
+				t.Errorf("TopK = %v, want %v", got, tt.want)
+			}
+			for id, want := range tt.errs {
+				if got := ss.ItemError(id); got != want {
+					t.Errorf("ItemError(%s) = %d, want %d", id, got, want)
+				}
+			}
+			for id, want := range tt.counts {
+				if got := ss.GetCount(id); got != want {
+					t.Errorf("GetCount(%s) = %d, want %d", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSpaceSavingGuarantees(t *testing.T) {
+	// 计数不低于真实值，高估不超过继承的误差；频次超过 N/容量 的条目必被追踪
+	ss := NewSpaceSaving(8, 0.001, 0.01)
+	truth := make(map[string]int)
+	for i := range 2000 {
+		// 少数热门条目与大量长尾条目交替
+		id := fmt.Sprintf("tail%d", i%97)
+		if i%5 != 0 {
+			id = fmt.Sprintf("hot%d", i%4)
+		}
+		truth[id]++
+		ss.Adjust(id, 1)
+	}
+	for _, it := range ss.TopK(8) {
+		n := truth[it.DocID]
+		if it.Clicks < n || it.Clicks-ss.ItemError(it.DocID) > n {
+			t.Errorf("%s: estimate %d (error %d), true %d", it.DocID, it.Clicks, ss.ItemError(it.DocID), n)
+		}
+	}
+	for i := range 4 {
+		id := fmt.Sprintf("hot%d", i)
+		if !slices.ContainsFunc(ss.TopK(8), func(it RankItem) bool { return it.DocID == id }) {
+			t.Errorf("heavy hitter %s not tracked", id)
+		}
+	}
+	if info := ss.ApproxInfo(); info.Total != 2000 || info.Monitored != 8 || info.MaxError == 0 {
+		t.Errorf("ApproxInfo = %+v", info)
+	}
+}
+
+func TestSpaceSavingModelRoundTrip(t *testing.T) {
+	ss := NewSpaceSaving(2, 0.01, 0.1)
+	for _, op := range []bucketOp{{id: "a", delta: 3}, {id: "b", delta: 2}, {id: "c", delta: 1}} {
+		ss.Adjust(op.id, op.delta)
+	}
+	m := ss.model()
+
+	restored := NewSpaceSaving(2, 0.01, 0.1)
+	if !restored.restore(m) {
+		t.Fatal("restore with the same parameters failed")
+	}
+	// 同计数条目的先后在恢复后不保证一致，按集合比较
+	got, want := rankString(restored.TopK(2)), rankString(ss.TopK(2))
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("TopK = %v, want %v", got, want)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if restored.GetCount(id) != ss.GetCount(id) || restored.ItemError(id) != ss.ItemError(id) {
+			t.Errorf("%s: restored %d/%d, want %d/%d", id, restored.GetCount(id), restored.ItemError(id), ss.GetCount(id), ss.ItemError(id))
+		}
+	}
+	// 修改原结构不影响已导出的状态
+	ss.Adjust("a", 5)
+	if m.Counts["a"] != 3 {
+		t.Errorf("model shares state with the sketch: a = %d", m.Counts["a"])
+	}
+
+	for name, other := range map[string]*SpaceSaving{
+		"smaller capacity":  NewSpaceSaving(1, 0.01, 0.1),
+		"different epsilon": NewSpaceSaving(2, 0.001, 0.1),
+		"different delta":   NewSpaceSaving(2, 0.01, 0.001),
+	} {
+		if other.restore(m) {
+			t.Errorf("%s: restore should fail", name)
+		}
+	}
+}
+
+func TestCountMinNeverUnderestimates(t *testing.T) {
+	cm := NewCountMin(0.01, 0.01)
+	truth := make(map[string]int)
+	for i := range 5000 {
+		id := fmt.Sprintf("id%d", i%700)
+		cm.Add(id, 1)
+		truth[id]++
+	}
+	// 减量后仍不低估
+	for i := range 100 {
+		id := fmt.Sprintf("id%d", i)
+		cm.Add(id, -2)
+		truth[id] -= 2
+	}
+	for id, n := range truth {
+		if est := cm.Estimate(id); est < n {
+			t.Fatalf("Estimate(%s) = %d, below true %d", id, est, n)
+		}
+	}
+	cm.Reset()
+	if est := cm.Estimate("id1"); est != 0 {
+		t.Errorf("Estimate after Reset = %d", est)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// 点击来源维度
+const (
+	dimReferrer = "referrer"
+	dimCampaign = "campaign"
+	dimApp      = "app"
+	dimLocale   = "locale"
+)
+
+// sourceDims 为全部来源维度
+var sourceDims = []string{dimReferrer, dimCampaign, dimApp, dimLocale}
+
+// sourceOther 为超出基数上限后归并的取值
+const sourceOther = "(other)"
+
+// ClickSource 为点击的来源维度，均为可选
+type ClickSource struct {
+	Referrer string `json:"referrer,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	App      string `json:"app,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+}
+
+// IsZero 判断是否未携带任何来源
+func (cs ClickSource) IsZero() bool {
+	return cs == ClickSource{}
+}
+
+// get 按维度名返回取值
+func (cs ClickSource) get(dim string) string {
+	switch dim {
+	case dimReferrer:
+		return cs.Referrer
+	case dimCampaign:
+		return cs.Campaign
+	case dimApp:
+		return cs.App
+	case dimLocale:
+		return cs.Locale
+	}
+	return ""
+}
+
+// normalize 去除首尾空白并截断；referrer 去掉协议、查询串与片段以控制基数
+func (cs ClickSource) normalize(maxLen int) ClickSource {
+	clean := func(v string) string {
+		v = strings.TrimSpace(v)
+		if maxLen > 0 && len(v) > maxLen {
+			// 按字符边界截断，避免切开多字节 UTF-8
+			i := maxLen
+			for i > 0 && !utf8.RuneStart(v[i]) {
+				i--
+			}
+			v = v[:i]
+		}
+		return v
+	}
+	if u, err := url.Parse(strings.TrimSpace(cs.Referrer)); err == nil && u.Host != "" {
+		cs.Referrer = u.Host + u.Path
+	}
+	cs.Referrer = clean(cs.Referrer)
+	cs.Campaign = clean(cs.Campaign)
+	cs.App = clean(cs.App)
+	cs.Locale = strings.ToLower(clean(cs.Locale))
+	return cs
+}
+
+// SourceCount 为某一来源取值的点击数
+type SourceCount struct {
+	Value  string `json:"value"`
+	Clicks int    `json:"clicks"`
+}
+
+// Sources 按 维度 -> 取值 维护文档点击计数，每个取值一份 Buckets 以支持按来源取榜
+// 每个维度的取值数量有上限，超出部分归入 (other)；由 Store 的锁保护
+type Sources struct {
+	dims      map[string]map[string]*Buckets
+	maxValues int
+}
+
+// NewSources 创建来源统计
+func NewSources(maxValues int) *Sources {
+	s := &Sources{dims: make(map[string]map[string]*Buckets, len(sourceDims)), maxValues: maxValues}
+	for _, d := range sourceDims {
+		s.dims[d] = make(map[string]*Buckets)
+	}
+	return s
+}
+
+// bucketsFor 返回取值对应的 Buckets，必要时按上限归入 (other)
+func (s *Sources) bucketsFor(dim, value string) *Buckets {
+	values := s.dims[dim]
+	if b, ok := values[value]; ok {
+		return b
+	}
+	if s.maxValues > 0 && len(values) >= s.maxValues {
+		value = sourceOther
+		if b, ok := values[value]; ok {
+			return b
+		}
+	}
+	// 取值可多达 维度数 × maxValues 个，不预分配
+	b := newBucketsSize(0)
+	values[value] = b
+	return b
+}
+
+// Add 为文档在各个非空来源维度上计数
+func (s *Sources) Add(docID string, src ClickSource, n int) {
+	for _, dim := range sourceDims {
+		v := src.get(dim)
+		if v == "" {
+			continue
+		}
+		s.bucketsFor(dim, v).Adjust(docID, n)
+	}
+}
+
+// Delete 从全部来源中移除文档
+func (s *Sources) Delete(docID string) {
+	for _, values := range s.dims {
+		for _, b := range values {
+			b.Delete(docID)
+		}
+	}
+}
+
+// Board 返回某来源取值的榜单，不存在时返回 nil
+func (s *Sources) Board(dim, value string) *Buckets {
+	return s.dims[dim][value]
+}
+
+// Breakdown 返回文档在某维度上各取值的点击数，按点击数降序
+func (s *Sources) Breakdown(docID, dim string) []SourceCount {
+	out := make([]SourceCount, 0)
+	for v, b := range s.dims[dim] {
+		if n := b.GetCount(docID); n > 0 {
+			out = append(out, SourceCount{Value: v, Clicks: n})
+		}
+	}
+	slices.SortFunc(out, func(p, q SourceCount) int {
+		if p.Clicks != q.Clicks {
+			return q.Clicks - p.Clicks
+		}
+		return strings.Compare(p.Value, q.Value)
+	})
+	return out
+}
+
+// Snapshot 导出 维度 -> 取值 -> 文档 -> 计数
+func (s *Sources) Snapshot() map[string]map[string]map[string]int {
+	out := make(map[string]map[string]map[string]int, len(s.dims))
+	for dim, values := range s.dims {
+		m := make(map[string]map[string]int, len(values))
+		for v, b := range values {
+			counts := make(map[string]int, len(b.entries))
+			for id, e := range b.entries {
+				if e.count > 0 {
+					counts[id] = e.count
+				}
+			}
+			m[v] = counts
+		}
+		out[dim] = m
+	}
+	return out
+}
+
+// Restore 从快照恢复，未知维度忽略
+func (s *Sources) Restore(snap map[string]map[string]map[string]int) {
+	for dim, values := range snap {
+		if _, ok := s.dims[dim]; !ok {
+			continue
+		}
+		for v, counts := range values {
+			s.bucketsFor(dim, v).ResetFromCounts(counts)
+		}
+	}
+}
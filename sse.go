@@ -1,55 +1,401 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 )
 
+// SSE 事件名
+const (
+	eventRank     = "rank"      // 完整榜单，连接建立时发送
+	eventRankDiff = "rank_diff" // 榜单差异
+	eventDoc      = "doc"       // 文档新增、修改或删除
+	eventAlert    = "alert"     // 告警规则进入 firing 或 resolved
+	eventResync   = "resync"    // 无法补发断线期间的事件，客户端需丢弃本地状态
+)
+
+// metaCategory 为文档分类所在的元数据键，用于按分类订阅
+const metaCategory = "category"
+
+// sseMessage 为已编码的事件，广播时只编码一次；v 为编码前的值，供过滤订阅裁剪
+type sseMessage struct {
+	id    uint64
+	event string
+	data  []byte
+	v     any
+}
+
+// 慢客户端 (发送缓冲已满) 的处理方式
+const (
+	slowResync     = "resync"     // 丢弃积压事件，改发 resync 与完整榜单
+	slowDisconnect = "disconnect" // 同 resync，但累计丢弃达到上限后断开连接
+)
+
+// 服务端断开连接的原因
+const (
+	closeSlow      = "slow consumer"
+	closeNamespace = "namespace closed"
+)
+
+// sseClient 为一个 SSE 或 WebSocket 连接
+type sseClient struct {
+	id        uint64
+	transport string // sse 或 ws
+	remote    string
+	key       string // API 密钥名称，未鉴权时为空
+	since     time.Time
+
+	ch     chan sseMessage
+	filter *sseFilter
+	// 已发给该连接的榜单版本；过滤订阅会跳过无关差异，需据此改写 base_stream_version
+	version uint64
+	// 以下计数受 SSEHub.mu 保护
+	dropped int
+	resyncs int
+
+	// lagged 表示有事件因缓冲已满被丢弃，写入方应丢弃积压并补发 resync
+	lagged atomic.Bool
+	// lastID 为已写出 (或因订阅条件跳过) 的最后事件编号，用于计算落后的事件数
+	lastID atomic.Uint64
+	// done 在连接被服务端断开时关闭：慢消费或命名空间关闭，原因见 closeReason
+	done        chan struct{}
+	closeReason string
+}
+
+// advance 记录已处理到的事件编号，只增不减
+func (cl *sseClient) advance(id uint64) {
+	for {
+		cur := cl.lastID.Load()
+		if id <= cur || cl.lastID.CompareAndSwap(cur, id) {
+			return
+		}
+	}
+}
+
 type SSEHub struct {
 	mu      sync.Mutex
-	clients map[chan string]struct{}
+	clients map[*sseClient]struct{}
+	// 最近一次推送后的完整榜单，新连接先收到它，之后的差异以它为基准
+	board *BoardState
+
+	// 事件编号单调递增，初值取启动时的微秒时间戳，重启前的编号总是更小；
+	// 对外的事件 ID 为 "<实例>-<编号>"，来自其他实例的 Last-Event-ID 不会误补发本实例的事件
+	seq      uint64
+	instance string
+	// 最近的事件，用于按 Last-Event-ID 补发
+	replay     []sseMessage
+	replaySize int
+
+	// 慢客户端处理方式，及 disconnect 方式下累计丢弃多少事件后断开
+	slowPolicy   string
+	slowMaxDrops int
+	nextClient   uint64
+	stats        SSEStats
+
+	// 事件经 Broker 分发，以便多实例部署时送达全部实例的客户端
+	ns     string
+	broker Broker
+	unsub  func()
+	// 各实例最近发布的完整榜单，按 Origin 区分；推送的榜单由它们按文档合并而成
+	replicas map[string]BoardState
+	topK     int
+	// closed 后不再登记新客户端
+	closed bool
+}
+
+// SSEStats 为事件推送统计，计数自启动起累计
+type SSEStats struct {
+	Clients     int   `json:"clients"`
+	Lagged      int   `json:"lagged"` // 当前待 resync 的连接数
+	Dropped     int64 `json:"dropped"`
+	Resyncs     int64 `json:"resyncs"`
+	Disconnects int64 `json:"disconnects"`
 }
 
-// NewSSEHub 创建 SSEHub
-func NewSSEHub() *SSEHub {
-	return &SSEHub{clients: make(map[chan string]struct{})}
+// SSEClientInfo 为连接状态，lag 为落后于最新事件的事件数
+type SSEClientInfo struct {
+	ID          uint64    `json:"id"`
+	Transport   string    `json:"transport"`
+	Remote      string    `json:"remote"`
+	Key         string    `json:"key,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Filter      gin.H     `json:"filter,omitempty"`
+	Queued      int       `json:"queued"`
+	Buffer      int       `json:"buffer"`
+	Lag         uint64    `json:"lag"`
+	Lagged      bool      `json:"lagged"`
+	Dropped     int       `json:"dropped"`
+	Resyncs     int       `json:"resyncs"`
+}
+
+// NewSSEHub 创建命名空间 ns 的 SSEHub 并订阅其 Broker 事件
+func NewSSEHub(ns string, broker Broker, cfg Config) *SSEHub {
+	h := &SSEHub{
+		clients:      make(map[*sseClient]struct{}),
+		seq:          uint64(time.Now().UnixMicro()),
+		replaySize:   cfg.SSEReplaySize,
+		slowPolicy:   cfg.SSESlowPolicy,
+		slowMaxDrops: cfg.SSESlowMaxDrops,
+		ns:           ns,
+		broker:       broker,
+		instance:     broker.ID(),
+		replicas:     make(map[string]BoardState),
+		topK:         cfg.SSETopK,
+	}
+	h.unsub = broker.Subscribe(ns, h.receive)
+	broker.Publish(ns, BrokerEvent{Type: brokerHello})
+	return h
+}
+
+// lastEventID 取客户端已收到的最后事件编号，EventSource 重连时会带上 Last-Event-ID 头
+// 返回原始值与编号；实例不符或无法解析时 ok 为 false，调用方应发送 resync
+func (h *SSEHub) lastEventID(c *gin.Context) (raw string, id uint64, ok bool) {
+	raw = c.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = c.Query("last_event_id")
+	}
+	i := strings.LastIndexByte(raw, '-')
+	if i < 0 || raw[:i] != h.instance {
+		return raw, 0, false
+	}
+	id, err := strconv.ParseUint(raw[i+1:], 10, 64)
+	return raw, id, err == nil
+}
+
+// eventID 返回事件编号对外的 ID，编号为 0 (如 resync) 时为空
+func (h *SSEHub) eventID(id uint64) string {
+	if id == 0 {
+		return ""
+	}
+	return h.instance + "-" + strconv.FormatUint(id, 10)
+}
+
+// resumeLocked 返回重连客户端需要补发的事件；缺口超出缓冲时返回 false
+func (h *SSEHub) resumeLocked(last uint64) ([]sseMessage, bool) {
+	if last == h.seq {
+		return nil, true
+	}
+	if last > h.seq || len(h.replay) == 0 || last+1 < h.replay[0].id {
+		return nil, false
+	}
+	// 缓冲按编号升序，且编号连续
+	return append([]sseMessage(nil), h.replay[last+1-h.replay[0].id:]...), true
+}
+
+// writeEvent 写出一个事件
+func writeEvent(c *gin.Context, id string, event string, data []byte) {
+	c.Render(-1, sse.Event{Id: id, Event: event, Data: string(data)})
+}
+
+// subscribe 登记客户端，返回连接建立后需先发送的事件：resync、完整榜单或补发的事件
+// 取补发事件或完整榜单与登记客户端在同一把锁内，保证不会漏掉或重复事件
+// 带 Last-Event-ID 重连时优先补发，缺口过大则先发 resync 再发完整榜单
+func (h *SSEHub) subscribe(c *gin.Context, filter *sseFilter, buffer int, transport string) (*sseClient, []sseMessage) {
+	cl := &sseClient{
+		transport: transport,
+		remote:    c.ClientIP(),
+		since:     time.Now(),
+		ch:        make(chan sseMessage, buffer),
+		filter:    filter,
+		done:      make(chan struct{}),
+	}
+	if v, ok := c.Get("apikey"); ok {
+		cl.key = v.(APIKey).Name
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextClient++
+	cl.id = h.nextClient
+	if h.closed {
+		cl.closeReason = closeNamespace
+		close(cl.done)
+		return cl, nil
+	}
+	cl.lastID.Store(h.seq)
+	var initial, missed []sseMessage
+	resumed := false
+	if raw, last, ok := h.lastEventID(c); raw != "" {
+		if ok {
+			missed, resumed = h.resumeLocked(last)
+		}
+		if resumed {
+			cl.lastID.Store(last)
+		} else {
+			b, _ := json.Marshal(gin.H{"last_event_id": raw, "current_event_id": h.eventID(h.seq)})
+			initial = append(initial, sseMessage{event: eventResync, data: b})
+		}
+	}
+	if h.board != nil {
+		cl.version = h.board.Version
+		if !resumed {
+			if msg, ok := h.fullBoardLocked(cl); ok {
+				initial = append(initial, msg)
+			}
+		}
+	}
+	// 补发的第一个差异的基准即为客户端断线时的榜单版本
+	for _, msg := range missed {
+		if d, ok := msg.v.(BoardDiff); ok {
+			cl.version = d.BaseVersion
+			break
+		}
+	}
+	for _, msg := range missed {
+		if msg, ok := cl.prepare(msg); ok {
+			initial = append(initial, msg)
+		}
+	}
+	h.clients[cl] = struct{}{}
+	return cl, initial
+}
+
+// fullBoardLocked 按连接的订阅裁剪完整榜单，编号为其对应的最后事件
+func (h *SSEHub) fullBoardLocked(cl *sseClient) (sseMessage, bool) {
+	cl.version = h.board.Version
+	return cl.prepare(sseMessage{id: h.seq, event: eventRank, v: *h.board})
+}
+
+// unsubscribe 注销客户端
+func (h *SSEHub) unsubscribe(cl *sseClient) {
+	h.mu.Lock()
+	delete(h.clients, cl)
+	h.mu.Unlock()
+}
+
+// resync 丢弃慢客户端积压的事件，返回应改发的 resync 与完整榜单
+// 由写入方在发现 lagged 后调用；积压的差异已包含在完整榜单中，文档变更由客户端重新获取
+func (h *SSEHub) resync(cl *sseClient) []sseMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for len(cl.ch) > 0 {
+		<-cl.ch
+	}
+	cl.lagged.Store(false)
+	cl.resyncs++
+	h.stats.Resyncs++
+	b, _ := json.Marshal(gin.H{"reason": "slow_consumer", "dropped": cl.dropped, "current_event_id": h.eventID(h.seq)})
+	out := []sseMessage{{event: eventResync, data: b}}
+	if h.board != nil {
+		if msg, ok := h.fullBoardLocked(cl); ok {
+			out = append(out, msg)
+		}
+	}
+	cl.advance(h.seq)
+	return out
+}
+
+// dropLocked 记录一次因缓冲已满丢弃的事件，按策略标记 resync 或断开连接
+func (h *SSEHub) dropLocked(cl *sseClient) {
+	cl.dropped++
+	h.stats.Dropped++
+	if h.slowPolicy == slowDisconnect && cl.dropped >= h.slowMaxDrops {
+		h.disconnectLocked(cl, closeSlow)
+		h.stats.Disconnects++
+		log.Printf("sse: disconnect slow client %d (%s %s) after %d dropped events", cl.id, cl.transport, cl.remote, cl.dropped)
+		return
+	}
+	cl.lagged.Store(true)
+}
+
+// disconnectLocked 注销客户端并通知其写入方断开
+func (h *SSEHub) disconnectLocked(cl *sseClient, reason string) {
+	delete(h.clients, cl)
+	cl.closeReason = reason
+	close(cl.done)
+}
+
+// Clients 返回当前连接及推送统计
+func (h *SSEHub) Clients() ([]SSEClientInfo, SSEStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.stats
+	st.Clients = len(h.clients)
+	out := make([]SSEClientInfo, 0, len(h.clients))
+	for cl := range h.clients {
+		info := SSEClientInfo{
+			ID:          cl.id,
+			Transport:   cl.transport,
+			Remote:      cl.remote,
+			Key:         cl.key,
+			ConnectedAt: cl.since,
+			Queued:      len(cl.ch),
+			Buffer:      cap(cl.ch),
+			Lagged:      cl.lagged.Load(),
+			Dropped:     cl.dropped,
+			Resyncs:     cl.resyncs,
+		}
+		if last := cl.lastID.Load(); last < h.seq {
+			info.Lag = h.seq - last
+		}
+		if f := cl.filter; f != nil {
+			info.Filter = gin.H{"types": f.types, "boards": f.boards, "docs": f.docs, "categories": f.categories}
+		}
+		if info.Lagged {
+			st.Lagged++
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, st
 }
 
 // Serve 提供 SSE 连接
+// 查询参数 types、boards、docs、categories (逗号分隔) 用于只订阅部分事件
 func (h *SSEHub) Serve(c *gin.Context) {
+	filter, err := parseSSEFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
 	// 禁用缓存
 	c.Header("Cache-Control", "no-cache")
 
-	ch := make(chan string, 32)
-	h.mu.Lock()
-	h.clients[ch] = struct{}{}
-	h.mu.Unlock()
+	cl, initial := h.subscribe(c, filter, 32, "sse")
+	defer h.unsubscribe(cl)
 	// 心跳，避免代理超时
 	ticker := time.NewTicker(25 * time.Second)
 	defer ticker.Stop()
 
 	ctxDone := c.Request.Context().Done()
+	write := func(msgs ...sseMessage) {
+		for _, msg := range msgs {
+			writeEvent(c, h.eventID(msg.id), msg.event, msg.data)
+			cl.advance(msg.id)
+		}
+	}
 
-	// 先发一次心跳
+	// 先发一次心跳，再补发或发送完整榜单
 	c.SSEvent("ping", gin.H{})
+	write(initial...)
+	c.Writer.Flush()
 
 	// 使用 gin 的 Stream + SSEvent
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case <-ctxDone:
-			h.mu.Lock()
-			delete(h.clients, ch)
-			h.mu.Unlock()
 			return false
-		case typ := <-ch:
-			// 仅发送更新锚点
-			if typ == "" {
-				typ = "update_click"
+		case <-cl.done:
+			// 被服务端断开：慢消费时 EventSource 会带 Last-Event-ID 重连，命名空间已删除时重连得到 404
+			return false
+		case msg := <-cl.ch:
+			if cl.lagged.Load() {
+				write(h.resync(cl)...)
+			} else {
+				write(msg)
 			}
-			c.SSEvent("update", gin.H{"type": typ})
 			return true
 		case <-ticker.C:
 			c.SSEvent("ping", gin.H{})
@@ -58,28 +404,309 @@ func (h *SSEHub) Serve(c *gin.Context) {
 	})
 }
 
-// BroadcastUpdateClick 广播点击更新
-func (h *SSEHub) BroadcastUpdateClick() {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	for ch := range h.clients {
+// broadcastLocked 编码、编号并发送给全部客户端，同时写入补发缓冲
+func (h *SSEHub) broadcastLocked(event string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("sse encode error: %v", err)
+		return
+	}
+	h.seq++
+	msg := sseMessage{id: h.seq, event: event, data: b, v: v}
+	if h.replaySize > 0 {
+		h.replay = append(h.replay, msg)
+		if len(h.replay) > h.replaySize {
+			h.replay = slices.Delete(h.replay, 0, len(h.replay)-h.replaySize)
+		}
+	}
+	for cl := range h.clients {
+		if cl.lagged.Load() {
+			// 已待 resync，之后的事件由完整榜单取代
+			continue
+		}
+		out, ok := cl.prepare(msg)
+		if !ok {
+			// 不在订阅范围内；没有积压时视为已处理到该事件
+			if len(cl.ch) == 0 {
+				cl.advance(msg.id)
+			}
+			continue
+		}
 		select {
-		case ch <- "update_click":
+		case cl.ch <- out:
 		default:
-			// 跳过慢客户端
+			h.dropLocked(cl)
+		}
+	}
+}
+
+// PublishBoard 经 Broker 发布新的完整榜单
+func (h *SSEHub) PublishBoard(full BoardState) {
+	h.broker.Publish(h.ns, BrokerEvent{Type: brokerBoard, Board: &full})
+}
+
+// PublishDocs 经 Broker 发布文档变更
+func (h *SSEHub) PublishDocs(ev DocEvent) {
+	h.broker.Publish(h.ns, BrokerEvent{Type: brokerDoc, Doc: &ev})
+}
+
+// PublishAlert 经 Broker 发布告警状态变化
+func (h *SSEHub) PublishAlert(ev AlertEvent) {
+	h.broker.Publish(h.ns, BrokerEvent{Type: brokerAlert, Alert: &ev})
+}
+
+// receive 处理 Broker 分发的事件，可能来自本实例或其他实例
+func (h *SSEHub) receive(ev BrokerEvent) {
+	var announce *BoardState
+	h.mu.Lock()
+	switch {
+	case ev.Type == brokerBoard && ev.Board != nil:
+		h.replicas[ev.Origin] = *ev.Board
+		h.applyBoardLocked(h.mergeLocked())
+	case ev.Type == brokerLeave:
+		if _, ok := h.replicas[ev.Origin]; ok {
+			delete(h.replicas, ev.Origin)
+			h.applyBoardLocked(h.mergeLocked())
+		}
+	case ev.Type == brokerDoc && ev.Doc != nil:
+		h.broadcastLocked(eventDoc, *ev.Doc)
+	case ev.Type == brokerAlert && ev.Alert != nil:
+		h.broadcastLocked(eventAlert, *ev.Alert)
+	case ev.Type == brokerHello && ev.Origin != h.instance:
+		// 新启动的实例需要本实例的榜单才能合并
+		if b, ok := h.replicas[h.instance]; ok {
+			announce = &b
+		}
+	}
+	h.mu.Unlock()
+	// 本地订阅者在 Publish 内同步收到，需在解锁后发布
+	if announce != nil {
+		h.PublishBoard(*announce)
+	}
+}
+
+// mergeLocked 按文档累加各实例的榜单并重新排名，取前 topK
+// 各实例只发布自己的前 topK，合并结果对排在各实例 topK 之外的文档是近似的
+func (h *SSEHub) mergeLocked() BoardState {
+	if len(h.replicas) == 1 {
+		for _, b := range h.replicas {
+			return b
+		}
+	}
+	out := BoardState{Boards: make(map[string][]BoardEntry)}
+	sums := make(map[string]map[string]*BoardEntry)
+	for _, b := range h.replicas {
+		out.Version = max(out.Version, b.Version)
+		for name, entries := range b.Boards {
+			m := sums[name]
+			if m == nil {
+				m = make(map[string]*BoardEntry)
+				sums[name] = m
+			}
+			for _, e := range entries {
+				if cur, ok := m[e.DocID]; ok {
+					cur.Clicks += e.Clicks
+				} else {
+					e := e
+					m[e.DocID] = &e
+				}
+			}
+		}
+	}
+	for name, m := range sums {
+		entries := make([]BoardEntry, 0, len(m))
+		for _, e := range m {
+			entries = append(entries, *e)
 		}
+		slices.SortFunc(entries, func(a, b BoardEntry) int {
+			if a.Clicks != b.Clicks {
+				return b.Clicks - a.Clicks
+			}
+			return strings.Compare(a.DocID, b.DocID)
+		})
+		if h.topK > 0 && len(entries) > h.topK {
+			entries = entries[:h.topK]
+		}
+		for i := range entries {
+			entries[i].Rank = i + 1
+		}
+		out.Boards[name] = entries
 	}
+	return out
 }
 
-// BroadcastUpdateDoc 广播文档更新
-func (h *SSEHub) BroadcastUpdateDoc() {
+// applyBoardLocked 以收到的完整榜单为最新状态，广播相对上一版本的差异
+// 其他实例的版本号可能落后于本地，版本号在本 hub 内保证递增，客户端的版本链因此不会中断
+func (h *SSEHub) applyBoardLocked(full BoardState) {
+	var prev BoardState
+	if h.board != nil {
+		prev = *h.board
+	}
+	diff := BoardDiff{BaseVersion: prev.Version, Boards: make(map[string]BoardChange, len(full.Boards))}
+	for name, entries := range full.Boards {
+		if ch := diffBoard(prev.Boards[name], entries); len(ch.Upsert) > 0 || len(ch.Remove) > 0 {
+			diff.Boards[name] = ch
+		}
+	}
+	if len(diff.Boards) == 0 && h.board != nil {
+		return
+	}
+	if full.Version <= prev.Version {
+		full.Version = prev.Version + 1
+	}
+	diff.Version = full.Version
+	h.board = &full
+	h.broadcastLocked(eventRankDiff, diff)
+}
+
+// Close 取消 Broker 订阅，通知其他实例移除本实例的榜单，并断开全部客户端
+func (h *SSEHub) Close() {
+	h.unsub()
+	h.broker.Publish(h.ns, BrokerEvent{Type: brokerLeave})
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	for ch := range h.clients {
-		select {
-		case ch <- "update_doc":
-		default:
-			// 跳过慢客户端
+	h.closed = true
+	for cl := range h.clients {
+		h.disconnectLocked(cl, closeNamespace)
+	}
+}
+
+// sseFilter 为 SSE 订阅条件，为空的条件不限制
+type sseFilter struct {
+	types      []string // rank、doc、alert
+	boards     []string
+	docs       []string
+	categories []string
+}
+
+// parseSSEFilter 解析订阅参数，未指定任何条件时返回 nil
+func parseSSEFilter(c *gin.Context) (*sseFilter, error) {
+	f := &sseFilter{
+		types:      splitList(c.Query("types")),
+		boards:     splitList(c.Query("boards")),
+		docs:       splitList(c.Query("docs")),
+		categories: splitList(c.Query("categories")),
+	}
+	for _, t := range f.types {
+		if t != "rank" && t != "doc" && t != "alert" {
+			return nil, errors.New("unknown event type " + t)
+		}
+	}
+	for _, b := range f.boards {
+		if !slices.Contains(rankBoardNames, b) {
+			return nil, ErrUnknownBoard
+		}
+	}
+	if len(f.types)+len(f.boards)+len(f.docs)+len(f.categories) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func (f *sseFilter) wantType(t string) bool {
+	return len(f.types) == 0 || slices.Contains(f.types, t)
+}
+
+func (f *sseFilter) wantBoard(name string) bool {
+	return len(f.boards) == 0 || slices.Contains(f.boards, name)
+}
+
+// wantDoc 判断文档是否在订阅范围内；category 未知 (如删除事件) 时只按文档 ID 判断
+func (f *sseFilter) wantDoc(id, category string, known bool) bool {
+	if len(f.docs) > 0 && !slices.Contains(f.docs, id) {
+		return false
+	}
+	if len(f.categories) > 0 && known && !slices.Contains(f.categories, category) {
+		return false
+	}
+	return true
+}
+
+// filterEntries 保留订阅范围内的榜单条目
+func (f *sseFilter) filterEntries(entries []BoardEntry) []BoardEntry {
+	var out []BoardEntry
+	for _, e := range entries {
+		if f.wantDoc(e.DocID, e.Category, true) {
+			out = append(out, e)
 		}
 	}
+	return out
+}
+
+// prepare 按连接的订阅裁剪事件，并维护该连接的榜单版本；返回 false 表示无需发送
+func (cl *sseClient) prepare(msg sseMessage) (sseMessage, bool) {
+	f := cl.filter
+	if f == nil {
+		if msg.data == nil {
+			msg.data, _ = json.Marshal(msg.v)
+		}
+		return msg, true
+	}
+	var v any
+	switch m := msg.v.(type) {
+	case BoardState:
+		if !f.wantType("rank") {
+			return msg, false
+		}
+		out := BoardState{Version: m.Version, Boards: make(map[string][]BoardEntry)}
+		for name, entries := range m.Boards {
+			if f.wantBoard(name) {
+				out.Boards[name] = f.filterEntries(entries)
+			}
+		}
+		v = out
+	case BoardDiff:
+		if !f.wantType("rank") {
+			return msg, false
+		}
+		out := BoardDiff{Version: m.Version, BaseVersion: cl.version, Boards: make(map[string]BoardChange)}
+		for name, ch := range m.Boards {
+			if !f.wantBoard(name) {
+				continue
+			}
+			var fc BoardChange
+			fc.Upsert = f.filterEntries(ch.Upsert)
+			for _, id := range ch.Remove {
+				if f.wantDoc(id, "", false) {
+					fc.Remove = append(fc.Remove, id)
+				}
+			}
+			if len(fc.Upsert) > 0 || len(fc.Remove) > 0 {
+				out.Boards[name] = fc
+			}
+		}
+		if len(out.Boards) == 0 {
+			return msg, false
+		}
+		if cl.version != 0 {
+			cl.version = m.Version
+		}
+		v = out
+	case DocEvent:
+		if !f.wantType("doc") {
+			return msg, false
+		}
+		out := DocEvent{Op: m.Op}
+		for _, d := range m.Docs {
+			if f.wantDoc(d.ID, d.Meta[metaCategory], m.Op != "delete") {
+				out.Docs = append(out.Docs, d)
+			}
+		}
+		if len(out.Docs) == 0 {
+			return msg, false
+		}
+		v = out
+	case AlertEvent:
+		if !f.wantType("alert") || (m.DocID != "" && !f.wantDoc(m.DocID, "", false)) {
+			return msg, false
+		}
+		v = m
+	default:
+		return msg, true
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return msg, false
+	}
+	return sseMessage{id: msg.id, event: msg.event, data: b, v: v}, true
 }
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestHub 创建实例 ID 为 test 的 SSEHub，补发缓冲为 replay 个事件
+func newTestHub(t *testing.T, replay int) (*SSEHub, *MemoryBroker) {
+	t.Helper()
+	cfg := LoadConfig()
+	cfg.SSEReplaySize = replay
+	broker := NewMemoryBroker("test")
+	h := NewSSEHub(defaultNamespace, broker, cfg)
+	t.Cleanup(h.Close)
+	return h, broker
+}
+
+// testContext 构造带 Last-Event-ID 头与查询参数的请求上下文
+func testContext(lastEventID, query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/events?"+query, nil)
+	if lastEventID != "" {
+		c.Request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	return c
+}
+
+func eventNames(msgs []sseMessage) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.event
+	}
+	return out
+}
+
+func TestSSELastEventID(t *testing.T) {
+	h, _ := newTestHub(t, 8)
+	tests := []struct {
+		header, query string
+		raw           string
+		id            uint64
+		ok            bool
+	}{
+		{header: "test-42", raw: "test-42", id: 42, ok: true},
+		{query: "last_event_id=test-7", raw: "test-7", id: 7, ok: true},
+		{header: "test-9", query: "last_event_id=test-7", raw: "test-9", id: 9, ok: true},
+		{header: "other-42", raw: "other-42"},
+		{header: "42", raw: "42"},
+		{header: "test-x", raw: "test-x"},
+		{header: "", raw: ""},
+	}
+	for _, tt := range tests {
+		raw, id, ok := h.lastEventID(testContext(tt.header, tt.query))
+		if raw != tt.raw || id != tt.id || ok != tt.ok {
+			t.Errorf("lastEventID(%q, %q) = %q, %d, %v; want %q, %d, %v", tt.header, tt.query, raw, id, ok, tt.raw, tt.id, tt.ok)
+		}
+	}
+	if got := h.eventID(0); got != "" {
+		t.Errorf("eventID(0) = %q, want empty", got)
+	}
+	if got := h.eventID(5); got != "test-5" {
+		t.Errorf("eventID(5) = %q, want test-5", got)
+	}
+}
+
+func TestSSEResume(t *testing.T) {
+	h, _ := newTestHub(t, 3)
+	base := h.seq
+	h.mu.Lock()
+	if _, ok := h.resumeLocked(base); !ok {
+		t.Error("resume at the current event should succeed with an empty buffer")
+	}
+	if _, ok := h.resumeLocked(base - 1); ok {
+		t.Error("resume before the current event needs a buffer")
+	}
+	h.mu.Unlock()
+
+	for range 5 {
+		h.PublishDocs(DocEvent{Op: "upsert", Docs: []Doc{{ID: "a"}}})
+	}
+	tests := []struct {
+		last uint64 // 相对 base
+		want []uint64
+		ok   bool
+	}{
+		{last: 5, ok: true},
+		{last: 4, want: []uint64{5}, ok: true},
+		{last: 2, want: []uint64{3, 4, 5}, ok: true},
+		{last: 1}, // 缺口超出缓冲
+		{last: 6}, // 来自未来的编号
+		{last: 0},
+	}
+	for _, tt := range tests {
+		h.mu.Lock()
+		msgs, ok := h.resumeLocked(base + tt.last)
+		h.mu.Unlock()
+		var got []uint64
+		for _, m := range msgs {
+			got = append(got, m.id-base)
+		}
+		if ok != tt.ok || !slices.Equal(got, tt.want) {
+			t.Errorf("resume from %d = %v, %v; want %v, %v", tt.last, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSSESubscribeResume(t *testing.T) {
+	h, _ := newTestHub(t, 8)
+	base := h.seq
+	h.PublishBoard(BoardState{Version: 1, Boards: map[string][]BoardEntry{"total": {{DocID: "a", Rank: 1, Clicks: 1}}}})
+	h.PublishDocs(DocEvent{Op: "upsert", Docs: []Doc{{ID: "a"}}})
+	h.PublishDocs(DocEvent{Op: "delete", Docs: []Doc{{ID: "b"}}})
+
+	tests := []struct {
+		name, last string
+		want       []string
+	}{
+		{name: "new connection gets the full board", want: []string{eventRank}},
+		{name: "resume replays missed events", last: h.eventID(base + 1), want: []string{eventDoc, eventDoc}},
+		{name: "resume from the first event", last: h.eventID(base), want: []string{eventRankDiff, eventDoc, eventDoc}},
+		{name: "up to date", last: h.eventID(base + 3), want: []string{}},
+		{name: "gap beyond the buffer", last: h.eventID(base - 10), want: []string{eventResync, eventRank}},
+		{name: "other instance", last: "other-" + h.eventID(base + 1)[len("test-"):], want: []string{eventResync, eventRank}},
+		{name: "legacy numeric id", last: "12345", want: []string{eventResync, eventRank}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl, initial := h.subscribe(testContext(tt.last, ""), nil, 8, "sse")
+			defer h.unsubscribe(cl)
+			if got := eventNames(initial); !slices.Equal(got, tt.want) {
+				t.Errorf("initial events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// describeDiff 将发送的差异记为 "基准>版本 榜单..."，未发送时为 "-"
+func describeDiff(msg sseMessage, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	d := msg.v.(BoardDiff)
+	names := slices.Sorted(maps.Keys(d.Boards))
+	return fmt.Sprintf("%d>%d %s", d.BaseVersion, d.Version, strings.Join(names, ","))
+}
+
+func TestSSEPrepareRewritesBaseVersion(t *testing.T) {
+	a := BoardEntry{DocID: "a", Clicks: 5, Category: "x"}
+	b := BoardEntry{DocID: "b", Clicks: 3, Category: "y"}
+	diffs := []BoardDiff{
+		{BaseVersion: 1, Version: 2, Boards: map[string]BoardChange{"total": {Upsert: []BoardEntry{b}}}},
+		{BaseVersion: 2, Version: 3, Boards: map[string]BoardChange{"total": {Upsert: []BoardEntry{a}}, "recent": {Upsert: []BoardEntry{a}}}},
+		{BaseVersion: 3, Version: 4, Boards: map[string]BoardChange{"total": {Remove: []string{"a"}}}},
+		{BaseVersion: 4, Version: 5, Boards: map[string]BoardChange{"recent": {Upsert: []BoardEntry{b}}}},
+		{BaseVersion: 5, Version: 6, Boards: map[string]BoardChange{"total": {Upsert: []BoardEntry{a, b}}}},
+	}
+	tests := []struct {
+		name   string
+		filter *sseFilter
+		want   []string
+	}{
+		{
+			name: "unfiltered keeps every diff",
+			want: []string{"1>2 total", "2>3 recent,total", "3>4 total", "4>5 recent", "5>6 total"},
+		},
+		{
+			name:   "skipped diffs are folded into the next base",
+			filter: &sseFilter{docs: []string{"a"}, boards: []string{"total"}},
+			want:   []string{"-", "1>3 total", "3>4 total", "-", "4>6 total"},
+		},
+		{
+			name:   "removals of unknown category pass the category filter",
+			filter: &sseFilter{categories: []string{"x"}},
+			want:   []string{"-", "1>3 recent,total", "3>4 total", "-", "4>6 total"},
+		},
+		{
+			name:   "board filter only",
+			filter: &sseFilter{boards: []string{"recent"}},
+			want:   []string{"-", "1>3 recent", "-", "3>5 recent", "-"},
+		},
+		{
+			name:   "rank events not subscribed",
+			filter: &sseFilter{types: []string{"doc"}},
+			want:   []string{"-", "-", "-", "-", "-"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &sseClient{filter: tt.filter, version: 1}
+			var got []string
+			for i, d := range diffs {
+				got = append(got, describeDiff(cl.prepare(sseMessage{id: uint64(i + 1), event: eventRankDiff, v: d})))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("prepared diffs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSEPrepareFiltersEvents(t *testing.T) {
+	board := BoardState{Version: 7, Boards: map[string][]BoardEntry{
+		"total":  {{DocID: "a", Category: "x"}, {DocID: "b", Category: "y"}},
+		"recent": {{DocID: "b", Category: "y"}},
+	}}
+	upsert := DocEvent{Op: "upsert", Docs: []Doc{{ID: "a", Meta: map[string]string{metaCategory: "x"}}, {ID: "b"}}}
+	del := DocEvent{Op: "delete", Docs: []Doc{{ID: "a"}, {ID: "b"}}}
+	alert := AlertEvent{RuleID: "r", DocID: "b"}
+	tests := []struct {
+		name   string
+		filter *sseFilter
+		msg    any
+		want   string // 发送内容的 JSON，未发送时为空
+	}{
+		{name: "board pruned to boards and docs", filter: &sseFilter{boards: []string{"total"}, docs: []string{"b"}}, msg: board,
+			want: `{"stream_version":7,"boards":{"total":[{"doc_id":"b","rank":0,"clicks":0,"category":"y"}]}}`},
+		{name: "board by category keeps empty boards", filter: &sseFilter{categories: []string{"x"}}, msg: board,
+			want: `{"stream_version":7,"boards":{"recent":null,"total":[{"doc_id":"a","rank":0,"clicks":0,"category":"x"}]}}`},
+		{name: "board not subscribed", filter: &sseFilter{types: []string{"alert"}}, msg: board},
+		{name: "doc upsert by category", filter: &sseFilter{categories: []string{"x"}}, msg: upsert,
+			want: `{"op":"upsert","docs":[{"id":"a","title":"","url":"","meta":{"category":"x"}}]}`},
+		{name: "doc delete ignores category", filter: &sseFilter{categories: []string{"x"}}, msg: del,
+			want: `{"op":"delete","docs":[{"id":"a","title":"","url":""},{"id":"b","title":"","url":""}]}`},
+		{name: "doc outside the doc filter", filter: &sseFilter{docs: []string{"c"}}, msg: upsert},
+		{name: "alert for a subscribed doc", filter: &sseFilter{docs: []string{"b"}}, msg: alert,
+			want: `{"id":"","rule_id":"r","kind":"","state":"","doc_id":"b","value":0,"message":"","time":"0001-01-01T00:00:00Z"}`},
+		{name: "alert for another doc", filter: &sseFilter{docs: []string{"a"}}, msg: alert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &sseClient{filter: tt.filter}
+			out, ok := cl.prepare(sseMessage{id: 1, v: tt.msg})
+			got := ""
+			if ok {
+				got = string(out.data)
+			}
+			if got != tt.want {
+				t.Errorf("prepare = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// boardString 将榜单记为 "名次.文档:点击数"
+func boardString(entries []BoardEntry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = fmt.Sprintf("%d.%s:%d", e.Rank, e.DocID, e.Clicks)
+	}
+	return out
+}
+
+func TestSSEMergeReplicas(t *testing.T) {
+	board := func(version uint64, entries ...BoardEntry) BoardState {
+		return BoardState{Version: version, Boards: map[string][]BoardEntry{"total": entries}}
+	}
+	e := func(id string, clicks int) BoardEntry { return BoardEntry{DocID: id, Clicks: clicks} }
+	tests := []struct {
+		name     string
+		replicas map[string]BoardState
+		topK     int
+		want     []string
+	}{
+		{
+			name:     "single replica is used as is",
+			replicas: map[string]BoardState{"test": board(3, BoardEntry{DocID: "a", Rank: 1, Clicks: 2})},
+			want:     []string{"1.a:2"},
+		},
+		{
+			name: "clicks are summed per doc and re-ranked",
+			replicas: map[string]BoardState{
+				"test": board(3, e("a", 5), e("b", 4)),
+				"r2":   board(9, e("b", 3), e("c", 1)),
+			},
+			want: []string{"1.b:7", "2.a:5", "3.c:1"},
+		},
+		{
+			name: "ties ordered by doc id and cut to top-K",
+			replicas: map[string]BoardState{
+				"test": board(1, e("c", 2), e("a", 1)),
+				"r2":   board(1, e("b", 2)),
+				"r3":   board(1, e("d", 1)),
+			},
+			topK: 3,
+			want: []string{"1.b:2", "2.c:2", "3.a:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHub(t, 8)
+			h.topK = tt.topK
+			h.replicas = tt.replicas
+			got := h.mergeLocked()
+			if s := boardString(got.Boards["total"]); !slices.Equal(s, tt.want) {
+				t.Errorf("merged total = %v, want %v", s, tt.want)
+			}
+			var version uint64
+			for _, b := range tt.replicas {
+				version = max(version, b.Version)
+			}
+			if got.Version != version {
+				t.Errorf("merged version = %d, want %d", got.Version, version)
+			}
+		})
+	}
+}
+
+func TestSSEReplicaEvents(t *testing.T) {
+	h, broker := newTestHub(t, 8)
+	var announced []string
+	unsub := broker.Subscribe(defaultNamespace, func(ev BrokerEvent) {
+		if ev.Type == brokerBoard {
+			announced = append(announced, ev.Origin)
+		}
+	})
+	defer unsub()
+	total := func() []string {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return boardString(h.board.Boards["total"])
+	}
+	version := func() uint64 {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.board.Version
+	}
+
+	h.PublishBoard(BoardState{Version: 5, Boards: map[string][]BoardEntry{"total": {{DocID: "a", Rank: 1, Clicks: 2}}}})
+	broker.deliver(defaultNamespace, BrokerEvent{Type: brokerBoard, Origin: "r2",
+		Board: &BoardState{Version: 2, Boards: map[string][]BoardEntry{"total": {{DocID: "a", Rank: 1, Clicks: 3}}}}})
+	if got, want := total(), []string{"1.a:5"}; !slices.Equal(got, want) {
+		t.Errorf("after r2 board = %v, want %v", got, want)
+	}
+	// 其他实例的版本号落后时，hub 的版本仍递增
+	if v := version(); v != 6 {
+		t.Errorf("version after r2 board = %d, want 6", v)
+	}
+
+	announced = nil
+	broker.deliver(defaultNamespace, BrokerEvent{Type: brokerHello, Origin: "r3"})
+	if !slices.Equal(announced, []string{"test"}) {
+		t.Errorf("hello from r3 announced %v, want the local board", announced)
+	}
+	announced = nil
+	broker.deliver(defaultNamespace, BrokerEvent{Type: brokerHello, Origin: "test"})
+	if len(announced) != 0 {
+		t.Errorf("own hello announced %v", announced)
+	}
+
+	broker.deliver(defaultNamespace, BrokerEvent{Type: brokerLeave, Origin: "r2"})
+	if got, want := total(), []string{"1.a:2"}; !slices.Equal(got, want) {
+		t.Errorf("after r2 left = %v, want %v", got, want)
+	}
+	if v := version(); v != 7 {
+		t.Errorf("version after r2 left = %d, want 7", v)
+	}
+}
+
+func TestSSEBoardDiffs(t *testing.T) {
+	h, _ := newTestHub(t, 8)
+	cl, _ := h.subscribe(testContext("", ""), nil, 8, "sse")
+	defer h.unsubscribe(cl)
+	e := func(id string, rank, clicks int) BoardEntry { return BoardEntry{DocID: id, Rank: rank, Clicks: clicks} }
+	tests := []struct {
+		name    string
+		version uint64
+		total   []BoardEntry
+		want    string // "基准>版本 +新增或变化 -移除"，无事件时为 "-"
+	}{
+		{name: "first board", version: 1, total: []BoardEntry{e("a", 1, 1)}, want: "0>1 +[1.a:1] -[]"},
+		{name: "unchanged board sends nothing", version: 2, total: []BoardEntry{e("a", 1, 1)}, want: "-"},
+		{name: "new entry and rank change", version: 3, total: []BoardEntry{e("b", 1, 3), e("a", 2, 1)}, want: "1>3 +[1.b:3 2.a:1] -[]"},
+		{name: "stale version is bumped", version: 2, total: []BoardEntry{e("b", 1, 3)}, want: "3>4 +[] -[a]"},
+	}
+	for _, tt := range tests {
+		h.PublishBoard(BoardState{Version: tt.version, Boards: map[string][]BoardEntry{"total": tt.total}})
+		got := "-"
+		select {
+		case msg := <-cl.ch:
+			d := msg.v.(BoardDiff)
+			ch := d.Boards["total"]
+			got = fmt.Sprintf("%d>%d +%v -%v", d.BaseVersion, d.Version, boardString(ch.Upsert), ch.Remove)
+		default:
+		}
+		if got != tt.want {
+			t.Errorf("%s: diff = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
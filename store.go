@@ -1,32 +1,91 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
+var (
+	ErrUnknownBoard       = errors.New("unknown board")
+	ErrVersionNotFound    = errors.New("version not found")
+	ErrNoArchive          = errors.New("no archived leaderboard")
+	ErrSourceBoard        = errors.New("source filter only applies to the total board")
+	ErrDocLimit           = errors.New("document limit reached")
+	ErrRedirectNotAllowed = errors.New("redirect target not allowed")
+)
+
+// rankBoardNames 为参与快照的榜单名称
+var rankBoardNames = []string{"total", "recent"}
+
 type Store struct {
 	mu     sync.RWMutex
-	bkt    *Buckets
+	bkt    rankBoard
 	docs   *Docs
 	p      *Persist
 	sse    *SSEHub
 	config Config
 
-	recent   *Recent
-	lastPush time.Time
+	recent *Recent
+	pushed BoardState // 最近一次通过 SSE 推送的榜单
+	// 最近一次计入点击的时间，启动时取启动时间
+	lastClick time.Time
+	// SSE 推送合并：榜单差异与文档变更各自按间隔合并，pendingDocs 为待推送的文档 (nil 表示删除)
+	rankPush    *Coalescer
+	docPush     *Coalescer
+	pendingDocs map[string]*Doc
+
+	hist *RankHistory
+	arc  *RankArchive
+	ts   *TimeSeries
+	src  *Sources
+
+	autoReg *AutoRegister
+	filters *FilterPipeline
+	hooks   *Webhooks
+	quar    *Quarantine
 }
 
 // NewStore 创建 Store
 func NewStore(p *Persist, sse *SSEHub, cfg Config) *Store {
-	return &Store{
-		bkt:    NewBuckets(),
+	s := &Store{
+		bkt:    newBoard(cfg, "total"),
 		docs:   NewDocs(),
 		p:      p,
 		sse:    sse,
 		config: cfg,
-		recent: NewRecent(),
+		recent: NewRecent(newBoard(cfg, "recent")),
+		hist:   NewRankHistory(cfg.RankSnapshotRetention),
+		ts:     NewTimeSeries(cfg),
+		src:    NewSources(cfg.SourceMaxValues),
+		quar:   NewQuarantine(cfg.QuarantineMax, cfg.FilterFlagLog),
+
+		lastClick:   time.Now(),
+		pendingDocs: make(map[string]*Doc),
+	}
+	s.rankPush = NewCoalescer(cfg.SSERankInterval, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.publishBoardsLocked()
+	})
+	s.docPush = NewCoalescer(cfg.SSEDocInterval, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.flushDocsLocked()
+	})
+	return s
+}
+
+// newBoard 按配置为榜单选择精确或近似计数结构
+func newBoard(cfg Config, name string) rankBoard {
+	if slices.Contains(cfg.ApproxBoards, name) {
+		return NewSpaceSaving(cfg.ApproxCapacity, cfg.ApproxEpsilon, cfg.ApproxDelta)
 	}
+	return NewBuckets()
 }
 
 // Load 从恢复状态装载文档、总榜与最近榜
@@ -44,8 +103,22 @@ func (s *Store) Load(state *RestoreState) {
 			state.Counts[d.ID] = 0
 		}
 	}
-	// 用快照计数重建总榜
-	s.bkt.ResetFromCounts(state.Counts)
+	// 用快照计数重建总榜；近似榜单优先恢复保存的完整状态，参数变化时退回按计数重建
+	if ss, ok := s.bkt.(*SpaceSaving); !ok || state.Sketch == nil || !ss.restore(state.Sketch) {
+		s.bkt.ResetFromCounts(state.Counts)
+	}
+	// 来源计数恢复
+	s.src.Restore(state.Sources)
+	// 隔离区恢复
+	s.quar.restore(state.Quarantine)
+
+	// 时间序列恢复，并补上保存之后 WAL 中的点击
+	if state.Series != nil {
+		s.ts.restore(state.Series)
+	}
+	for _, e := range state.SeriesClicks {
+		s.ts.Add(e.ID, e.Ts, 1)
+	}
 
 	// 最近榜恢复
 	if len(state.RecentClicks) > 0 {
@@ -62,45 +135,359 @@ func (s *Store) Load(state *RestoreState) {
 			}
 		}
 	}
+	// 初始榜单，供 SSE 新连接使用
+	s.publishBoardsLocked()
 }
 
-// Click 记录一次点击并更新排行榜
-func (s *Store) Click(docID string) (int, bool, error) {
-	ts := time.Now().Unix()
+// EnableAutoRegister 开启未知 ID 的自动登记，并统计已有的占位文档
+func (s *Store) EnableAutoRegister(a *AutoRegister) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a.count = 0
+	for _, d := range s.docs.m {
+		if isAutoDoc(d) {
+			a.count++
+		}
+	}
+	s.autoReg = a
+}
 
+// EnableFilters 在点击计数前启用过滤器
+func (s *Store) EnableFilters(fp *FilterPipeline) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.filters = fp
+}
+
+// EnableWebhooks 启用回调，由榜单变化、点击里程碑与文档删除触发
+func (s *Store) EnableWebhooks(w *Webhooks) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = w
+}
+
+// Click 记录一次点击并更新排行榜，来源维度可为空
+// 文档不存在时，若开启自动登记且校验通过，则先登记占位文档，与点击写入同一批 WAL
+// 被过滤器丢弃或隔离的点击不计数，仍返回当前计数，避免向刷量方暴露检测结果
+func (s *Store) Click(ev ClickEvent) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clickLocked(ev, time.Now(), true)
+}
 
-	if _, ok := s.docs.Get(docID); !ok {
-		return 0, false, nil
+// clickLocked 按事件时间记录点击；filter 为 false 时跳过过滤器，用于审核通过的隔离点击
+func (s *Store) clickLocked(ev ClickEvent, now time.Time, filter bool) (int, bool, error) {
+	ts := now.Unix()
+	docID := ev.DocID
+	src := ev.Source.normalize(s.config.SourceMaxValueLen)
+
+	entries := make([]walEntry, 0, 2)
+	var placeholder *Doc
+	_, exists := s.docs.Get(docID)
+	if !exists {
+		if s.autoReg == nil {
+			return 0, false, nil
+		}
+		if err := s.autoReg.Check(ev, ts); err != nil {
+			if errors.Is(err, errAutoRegisterRejected) {
+				return 0, false, nil
+			}
+			return 0, false, err
+		}
+		if s.atDocLimitLocked(1) {
+			return 0, false, ErrAutoRegisterQuota
+		}
+	}
+	if filter && s.filters != nil {
+		act, reasons := s.filters.Evaluate(ev, now)
+		if act != FilterPass {
+			s.quar.record(FilteredClick{
+				DocID:     docID,
+				Ts:        ts,
+				Reasons:   reasons,
+				Source:    src,
+				ClientIP:  ev.ClientIP,
+				Visitor:   ev.Visitor,
+				UserAgent: ev.UserAgent,
+				Title:     ev.Title,
+				URL:       ev.URL,
+			}, act)
+		}
+		if act >= FilterQuarantine {
+			return s.bkt.GetCount(docID), true, nil
+		}
 	}
-	// 记录 WAL，带时间戳
-	if err := s.p.AppendWAL(walEntry{Op: "CLICK", ID: docID, Ts: ts}); err != nil {
+	if !exists {
+		d := s.autoReg.placeholder(ev)
+		placeholder = &d
+		entries = append(entries, walEntry{Op: "ADD", ID: d.ID, Title: d.Title, URL: d.URL, Meta: d.Meta})
+	}
+	// 记录 WAL，带时间戳与来源
+	e := walEntry{Op: "CLICK", ID: docID, Ts: ts}
+	if !src.IsZero() {
+		e.Src = &src
+	}
+	entries = append(entries, e)
+	if err := s.p.AppendWALBatch(entries); err != nil {
 		return 0, false, err
 	}
+	if placeholder != nil {
+		s.autoReg.Commit(ts)
+		s.bkt.Add(docID)
+		s.docs.Upsert(*placeholder)
+		s.queueDocsLocked(*placeholder)
+	}
 
-	// 总榜 +1
+	// 总榜 +1；近似榜单替换淘汰项时计数可能跳跃，里程碑按点击前的实际计数判断
+	prevCount := s.bkt.GetCount(docID)
 	newCount := s.bkt.Adjust(docID, +1)
 	// 最近榜 +1
 	s.recent.AddClick(docID, ts)
+	// 时间序列 +1
+	s.ts.Add(docID, ts, 1)
+	// 来源 +1
+	s.src.Add(docID, src, 1)
+	if now.After(s.lastClick) {
+		s.lastClick = now
+	}
+	if s.hooks != nil {
+		doc, _ := s.docs.Get(docID)
+		s.hooks.clicked(doc, prevCount, newCount)
+	}
 
 	// 节流后广播点击更新
 	s.maybeBroadcastTopKLocked()
 	return newCount, true, nil
 }
 
-// TopK 返回总榜前 K 项
-func (s *Store) TopK(k int) []RankItem {
+// TopK 返回总榜前 K 项，expand 时附带文档字段
+func (s *Store) TopK(k int, expand bool) []RankItem {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.bkt.TopK(k)
+	return s.expandLocked(s.bkt.TopK(k), expand)
 }
 
-// TopKRecent 返回最近榜前 K 项
-func (s *Store) TopKRecent(k int) []RankItem {
+// TopKRecent 返回最近榜前 K 项，expand 时附带文档字段
+func (s *Store) TopKRecent(k int, expand bool) []RankItem {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.recent.TopK(k)
+	return s.expandLocked(s.recent.TopK(k), expand)
+}
+
+// RankQuery 描述一次榜单查询
+type RankQuery struct {
+	K      int
+	Expand bool
+	// 对比基准：SinceVersion 优先，其次为 Since 之前的快照，均为零值时不对比
+	Since        time.Duration
+	SinceVersion uint64
+	// 非零时查询最接近该时刻的归档榜单，忽略对比基准
+	At time.Time
+	// 非零时按点击汇总计算 [From, To) 区间的榜单
+	From, To time.Time
+	// 非空时只统计该来源的点击，仅适用于总榜
+	SourceDim, SourceValue string
+}
+
+// HasRange 判断是否为区间查询
+func (q RankQuery) HasRange() bool {
+	return !q.From.IsZero() || !q.To.IsZero()
+}
+
+// RankBoards 在同一次读锁内返回多个榜单，保证彼此以及与文档集合一致
+func (s *Store) RankBoards(boards []string, q RankQuery) (map[string]RankResp, error) {
+	if !q.At.IsZero() {
+		return s.rankBoardsAt(boards, q)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var base *rankSnapshot
+	switch {
+	case q.SourceDim != "":
+		// 快照不区分来源，不计算名次变化
+	case q.SinceVersion > 0:
+		snap, ok := s.hist.ByVersion(q.SinceVersion)
+		if !ok {
+			return nil, ErrVersionNotFound
+		}
+		base = snap
+	case q.Since > 0:
+		base, _ = s.hist.Before(time.Now().Add(-q.Since))
+	}
+	var version uint64
+	if latest, ok := s.hist.Latest(); ok {
+		version = latest.Version
+	}
+
+	out := make(map[string]RankResp, len(boards))
+	for _, name := range boards {
+		b, ok := s.boardLocked(name)
+		if !ok {
+			return nil, ErrUnknownBoard
+		}
+		if q.SourceDim != "" {
+			if name != "total" {
+				return nil, ErrSourceBoard
+			}
+			sb := s.src.Board(q.SourceDim, q.SourceValue)
+			if sb == nil {
+				sb = newBucketsSize(0)
+			}
+			b = sb
+		}
+		resp := RankResp{Rank: b.TopK(q.K), Version: version}
+		if ab, ok := b.(approxBoard); ok {
+			info := ab.ApproxInfo()
+			resp.Approx = &info
+			for i := range resp.Rank {
+				resp.Rank[i].Error = ab.ItemError(resp.Rank[i].DocID)
+			}
+		}
+		if base != nil {
+			applyDeltas(resp.Rank, base.Boards[name])
+			resp.BaseVersion = base.Version
+			resp.BaseAt = base.At.Unix()
+		}
+		resp.Rank = s.expandLocked(resp.Rank, q.Expand)
+		out[name] = resp
+	}
+	return out, nil
+}
+
+// RankRange 基于小时、天汇总计算任意区间的前 K 项
+// 与对齐边界一致的区间结果精确；未对齐的边缘退到更细粒度，仍不足一个桶时按重叠时长占比估算
+func (s *Store) RankRange(q RankQuery) RankResp {
+	now := time.Now()
+	from, to := q.From, q.To
+	if to.IsZero() {
+		// 包含当前这一秒
+		to = now.Add(time.Second)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	items, approx := s.ts.RangeTopK(from.Unix(), to.Unix(), now.Unix(), q.K)
+	return RankResp{
+		Rank:        s.expandLocked(items, q.Expand),
+		From:        from.Unix(),
+		To:          to.Unix(),
+		Approximate: approx,
+	}
+}
+
+// Compare 基于点击汇总对比两个区间，返回各文档计数变化及涨跌前 k 项
+func (s *Store) Compare(cur, prev TimeRange, k int, expand bool) CompareResp {
+	now := time.Now().Unix()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	curCounts, approxCur := s.ts.RangeCounts(cur.From, cur.To, now)
+	prevCounts, approxPrev := s.ts.RangeCounts(prev.From, prev.To, now)
+	items, risers, fallers := buildCompare(curCounts, prevCounts, k)
+	if expand {
+		for _, list := range [][]CompareItem{items, risers, fallers} {
+			for i := range list {
+				if d, ok := s.docs.Get(list[i].DocID); ok {
+					list[i].Title, list[i].URL = d.Title, d.URL
+				}
+			}
+		}
+	}
+	return CompareResp{
+		Current:     cur,
+		Previous:    prev,
+		Approximate: approxCur || approxPrev,
+		Items:       items,
+		Risers:      risers,
+		Fallers:     fallers,
+	}
+}
+
+// rankBoardsAt 从归档中返回最接近 q.At 的榜单；expand 时按当前文档补充字段，已删除文档保留 ID
+func (s *Store) rankBoardsAt(boards []string, q RankQuery) (map[string]RankResp, error) {
+	if s.arc == nil {
+		return nil, ErrNoArchive
+	}
+	snap, ok := s.arc.Closest(q.At.Unix())
+	if !ok {
+		return nil, ErrNoArchive
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]RankResp, len(boards))
+	for _, name := range boards {
+		if _, ok := s.boardLocked(name); !ok {
+			return nil, ErrUnknownBoard
+		}
+		src := snap.Boards[name]
+		n := min(q.K, len(src))
+		items := make([]RankItem, 0, n)
+		for _, it := range src[:n] {
+			if q.Expand {
+				if d, ok := s.docs.Get(it.DocID); ok {
+					it.Title, it.URL, it.Meta = d.Title, d.URL, d.Meta
+				}
+			}
+			items = append(items, it)
+		}
+		out[name] = RankResp{Rank: items, At: snap.At}
+	}
+	return out, nil
+}
+
+// expandLocked 为榜单条目补充文档字段，并剔除已不存在的文档
+func (s *Store) expandLocked(items []RankItem, expand bool) []RankItem {
+	if !expand {
+		return items
+	}
+	out := items[:0]
+	for _, it := range items {
+		d, ok := s.docs.Get(it.DocID)
+		if !ok {
+			continue
+		}
+		it.Title, it.URL, it.Meta = d.Title, d.URL, d.Meta
+		out = append(out, it)
+	}
+	return out
+}
+
+// boardLocked 按名称返回榜单，新增窗口时在此登记
+func (s *Store) boardLocked(name string) (rankBoard, bool) {
+	switch name {
+	case "", "total":
+		return s.bkt, true
+	case "recent":
+		return s.recent.bkt, true
+	}
+	return nil, false
+}
+
+// RankExport 在一次读锁内复制榜单的 ID 与计数，limit 为 0 时复制全部
+// 导出按这份副本逐页写出，期间的点击不会造成重复或遗漏
+func (s *Store) RankExport(board string, limit int) ([]RankItem, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.boardLocked(board)
+	if !ok {
+		return nil, false
+	}
+	k := b.Len()
+	if limit > 0 {
+		k = min(k, limit)
+	}
+	return b.TopK(k), true
+}
+
+// RankExportRows 为导出的一页关联文档字段，名次从 rank+1 起；已删除的文档保留空的标题与链接
+func (s *Store) RankExportRows(items []RankItem, rank int) []RankExportItem {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]RankExportItem, len(items))
+	for i, it := range items {
+		d, _ := s.docs.Get(it.DocID)
+		out[i] = RankExportItem{Rank: rank + i + 1, DocID: it.DocID, Title: d.Title, URL: d.URL, Clicks: it.Clicks, Meta: d.Meta}
+	}
+	return out
 }
 
 // AddOrUpdateDoc 新增或更新文档
@@ -109,41 +496,245 @@ func (s *Store) AddOrUpdateDoc(doc Doc) error {
 	defer s.mu.Unlock()
 
 	op := "ADD"
-	if _, ok := s.docs.Get(doc.ID); ok {
+	old, ok := s.docs.Get(doc.ID)
+	if ok {
 		op = "UPDATE"
+	} else if s.atDocLimitLocked(1) {
+		return ErrDocLimit
 	}
-	if err := s.p.AppendWAL(walEntry{Op: op, ID: doc.ID, Title: doc.Title, URL: doc.URL}); err != nil {
+	if err := s.p.AppendWAL(walEntry{Op: op, ID: doc.ID, Title: doc.Title, URL: doc.URL, Meta: doc.Meta}); err != nil {
 		return err
 	}
 	if op == "ADD" {
 		s.bkt.Add(doc.ID)
 	}
+	s.trackAutoLocked(old, ok, doc)
 	s.docs.Upsert(doc)
-	// 广播文档更新
-	s.sse.BroadcastUpdateDoc()
+	// 广播文档更新，标题变化或新文档可能影响榜单
+	s.queueDocsLocked(doc)
+	s.maybeBroadcastTopKLocked()
 	return nil
 }
 
+// BulkUpsertDocs 批量新增或更新文档，整批写入一次 WAL；dryRun 时只统计不落盘
+func (s *Store) BulkUpsertDocs(docs []Doc, dryRun bool) (added, updated int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]walEntry, 0, len(docs))
+	seen := make(map[string]struct{}, len(docs))
+	for _, d := range docs {
+		op := "ADD"
+		if _, ok := s.docs.Get(d.ID); ok {
+			op = "UPDATE"
+		} else if _, ok := seen[d.ID]; ok {
+			// 同批次重复出现的 ID 视为更新
+			op = "UPDATE"
+		}
+		seen[d.ID] = struct{}{}
+		if op == "ADD" {
+			added++
+		} else {
+			updated++
+		}
+		entries = append(entries, walEntry{Op: op, ID: d.ID, Title: d.Title, URL: d.URL, Meta: d.Meta})
+	}
+	if s.atDocLimitLocked(added) {
+		return added, updated, ErrDocLimit
+	}
+	if dryRun || len(entries) == 0 {
+		return added, updated, nil
+	}
+	if err := s.p.AppendWALBatch(entries); err != nil {
+		return 0, 0, err
+	}
+	for _, d := range docs {
+		s.bkt.Add(d.ID)
+		old, ok := s.docs.Get(d.ID)
+		s.trackAutoLocked(old, ok, d)
+		s.docs.Upsert(d)
+	}
+	// 整批只广播一次
+	s.queueDocsLocked(docs...)
+	s.maybeBroadcastTopKLocked()
+	return added, updated, nil
+}
+
 // DeleteDoc 删除文档
 func (s *Store) DeleteDoc(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, ok := s.docs.Get(id); !ok {
+	old, ok := s.docs.Get(id)
+	if !ok {
 		return nil
 	}
 	if err := s.p.AppendWAL(walEntry{Op: "DEL", ID: id}); err != nil {
 		return err
 	}
+	if s.autoReg != nil && isAutoDoc(old) {
+		s.autoReg.count--
+	}
 	s.docs.Delete(id)
 	s.bkt.Delete(id)
 	// 立即从最近榜移除 id
 	s.recent.bkt.Delete(id)
+	s.ts.Delete(id)
+	s.src.Delete(id)
+	if s.hooks != nil {
+		s.hooks.deleted(old)
+	}
+
+	// 广播文档删除，并推送跌出榜单的差异
+	s.pendingDocs[id] = nil
+	if s.docPush.Trigger() {
+		s.flushDocsLocked()
+	}
+	s.maybeBroadcastTopKLocked()
+	return nil
+}
+
+// atDocLimitLocked 判断再新增 n 个文档是否超出命名空间文档上限
+func (s *Store) atDocLimitLocked(n int) bool {
+	return s.config.MaxDocs > 0 && n > 0 && len(s.docs.m)+n > s.config.MaxDocs
+}
+
+// DocCount 返回文档数
+func (s *Store) DocCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.docs.m)
+}
 
-	// 广播文档更新
-	s.sse.BroadcastUpdateDoc()
+// trackAutoLocked 文档由占位转为正式 (或相反) 时调整自动登记计数
+func (s *Store) trackAutoLocked(old Doc, existed bool, doc Doc) {
+	if s.autoReg == nil {
+		return
+	}
+	wasAuto := existed && isAutoDoc(old)
+	if wasAuto && !isAutoDoc(doc) {
+		s.autoReg.count--
+	} else if !wasAuto && isAutoDoc(doc) {
+		s.autoReg.count++
+	}
+}
+
+// GetDoc 返回文档
+func (s *Store) GetDoc(id string) (Doc, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.docs.Get(id)
+}
+
+// Clickable 判断能否为 id 签发点击令牌：文档存在，或开启了自动登记且 ID 符合规则 (链接与配额在点击时校验)
+func (s *Store) Clickable(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.docs.Get(id); ok {
+		return true
+	}
+	return s.autoReg != nil && s.autoReg.idAllowed(id)
+}
+
+// GoURL 返回跳转链接的目标；自动登记的占位文档只在链接域名位于白名单内时允许跳转，避免开放重定向
+func (s *Store) GoURL(id string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	doc, ok := s.docs.Get(id)
+	if !ok || doc.URL == "" {
+		return "", false, nil
+	}
+	if isAutoDoc(doc) && (s.autoReg == nil || !s.autoReg.redirectAllowed(doc.URL)) {
+		return "", true, ErrRedirectNotAllowed
+	}
+	return doc.URL, true, nil
+}
+
+// Quarantined 返回隔离区视图
+func (s *Store) Quarantined() QuarantineResp {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return QuarantineResp{
+		Stats:       s.quar.stats,
+		Quarantined: slices.Clone(s.quar.items),
+		Flagged:     slices.Clone(s.quar.flags),
+	}
+}
+
+// ApproveQuarantined 按原始时间计入隔离的点击，不再经过过滤器
+// 计入成功后才移出隔离区，失败 (如文档已删除) 时保留以便重试或丢弃
+func (s *Store) ApproveQuarantined(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fc, ok := s.quar.get(id)
+	if !ok {
+		return ErrQuarantineNotFound
+	}
+	_, ok, err := s.clickLocked(fc.event(), time.Unix(fc.Ts, 0), false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("document not found")
+	}
+	s.quar.take(id)
+	s.quar.stats.Approved++
+	return nil
+}
+
+// DiscardQuarantined 丢弃隔离的点击
+func (s *Store) DiscardQuarantined(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.quar.take(id); !ok {
+		return ErrQuarantineNotFound
+	}
+	s.quar.stats.Discarded++
 	return nil
 }
 
+// QuarantineSnapshot 导出隔离区用于落盘
+func (s *Store) QuarantineSnapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return json.Marshal(s.quar.items)
+}
+
+// BoardStat 返回文档在榜单中的计数，及其在前 topN 中的名次 (不在其中时为 0)
+func (s *Store) BoardStat(board, id string, topN int) (count, rank int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.boardLocked(board)
+	if !ok {
+		return 0, 0, ErrUnknownBoard
+	}
+	if topN > 0 {
+		for i, it := range b.TopK(topN) {
+			if it.DocID == id {
+				rank = i + 1
+				break
+			}
+		}
+	}
+	return b.GetCount(id), rank, nil
+}
+
+// LastClick 返回最近一次计入点击的时间
+func (s *Store) LastClick() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastClick
+}
+
+// ClickCount 返回文档的总点击数
+func (s *Store) ClickCount(id string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.docs.Get(id); !ok {
+		return 0, false
+	}
+	return s.bkt.GetCount(id), true
+}
+
 // ListDocs 返回全部文档
 func (s *Store) ListDocs() []Doc {
 	s.mu.RLock()
@@ -151,27 +742,232 @@ func (s *Store) ListDocs() []Doc {
 	return s.docs.List()
 }
 
-// CountsSnapshot 返回总榜计数快照
-func (s *Store) CountsSnapshot() map[string]int {
+// ExportDocs 返回按 ID 升序的文档及其当前计数
+func (s *Store) ExportDocs() []DocExportItem {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	out := make(map[string]int, len(s.bkt.entries))
-	for id, e := range s.bkt.entries {
-		out[id] = e.count
+	docs := s.docs.List()
+	out := make([]DocExportItem, 0, len(docs))
+	for _, d := range docs {
+		out = append(out, DocExportItem{
+			ID:           d.ID,
+			Title:        d.Title,
+			URL:          d.URL,
+			Clicks:       s.bkt.GetCount(d.ID),
+			RecentClicks: s.recent.bkt.GetCount(d.ID),
+			Meta:         d.Meta,
+		})
 	}
 	return out
 }
 
-// maybeBroadcastTopKLocked 节流后广播点击更新
+// DocTimeSeries 返回文档在 [from, to) 内按 step 秒聚合的点击序列
+func (s *Store) DocTimeSeries(id string, from, to, step int64) ([]TSPoint, string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.docs.Get(id); !ok {
+		return nil, "", false, nil
+	}
+	points, res, err := s.ts.Range(id, from, to, step, time.Now().Unix())
+	return points, res, true, err
+}
+
+// TimeSeriesSnapshot 清理过期数据并编码时间序列，连同当前 WAL 序号一起保存并返回该序号
+// 持锁期间只做清理与复制，编码在释放锁后进行，避免阻塞点击
+func (s *Store) TimeSeriesSnapshot() ([]byte, uint64, error) {
+	s.mu.Lock()
+	s.ts.Prune(time.Now().Unix())
+	seq := s.p.Seq()
+	m := s.ts.model(seq)
+	s.mu.Unlock()
+	b, err := json.Marshal(m)
+	return b, seq, err
+}
+
+// DocSources 返回文档在各来源维度上的点击分布，dim 为空时返回全部维度
+func (s *Store) DocSources(id, dim string) (map[string][]SourceCount, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.docs.Get(id); !ok {
+		return nil, false
+	}
+	dims := sourceDims
+	if dim != "" {
+		dims = []string{dim}
+	}
+	out := make(map[string][]SourceCount, len(dims))
+	for _, d := range dims {
+		out[d] = s.src.Breakdown(id, d)
+	}
+	return out, true
+}
+
+// SourcesSnapshot 返回来源计数快照
+func (s *Store) SourcesSnapshot() map[string]map[string]map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.src.Snapshot()
+}
+
+// CountsSnapshot 返回总榜计数快照；总榜为近似结构时计数只含已追踪条目，另返回其完整状态
+func (s *Store) CountsSnapshot() (map[string]int, *spaceSavingModel) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if ss, ok := s.bkt.(*SpaceSaving); ok {
+		m := ss.model()
+		return m.Counts, m
+	}
+	return s.bkt.Counts(), nil
+}
+
+// maybeBroadcastTopKLocked 请求推送榜单差异：距上次推送超过间隔时立即推送，
+// 否则合并到间隔结束时推送当时的最新榜单
 func (s *Store) maybeBroadcastTopKLocked() {
-	now := time.Now()
-	// 100 ms 内最多一次
-	if now.Sub(s.lastPush) < 100*time.Millisecond {
+	if s.rankPush.Trigger() {
+		s.publishBoardsLocked()
+	}
+}
+
+// StopPush 取消尚未执行的合并推送，关闭命名空间时调用
+func (s *Store) StopPush() {
+	s.rankPush.Stop()
+	s.docPush.Stop()
+}
+
+// queueDocsLocked 记录待推送的文档变更，按合并间隔推送
+func (s *Store) queueDocsLocked(docs ...Doc) {
+	for i := range docs {
+		s.pendingDocs[docs[i].ID] = &docs[i]
+	}
+	if s.docPush.Trigger() {
+		s.flushDocsLocked()
+	}
+}
+
+// flushDocsLocked 推送合并后的文档变更，同一文档只保留最后一次
+func (s *Store) flushDocsLocked() {
+	var upserts, deletes []Doc
+	for id, d := range s.pendingDocs {
+		if d == nil {
+			deletes = append(deletes, Doc{ID: id})
+		} else {
+			upserts = append(upserts, *d)
+		}
+	}
+	clear(s.pendingDocs)
+	byID := func(a, b Doc) int { return strings.Compare(a.ID, b.ID) }
+	slices.SortFunc(upserts, byID)
+	slices.SortFunc(deletes, byID)
+	if len(upserts) > 0 {
+		s.sse.PublishDocs(DocEvent{Op: "upsert", Docs: upserts})
+	}
+	if len(deletes) > 0 {
+		s.sse.PublishDocs(DocEvent{Op: "delete", Docs: deletes})
+	}
+}
+
+// publishBoardsLocked 计算各榜单前 SSETopK 项，相对上次推送有变化时分配新版本并发布完整榜单
+// 差异由 SSEHub 相对其已推送的版本计算
+func (s *Store) publishBoardsLocked() {
+	boards := make(map[string][]BoardEntry, len(rankBoardNames))
+	changed := s.pushed.Boards == nil
+	for _, name := range rankBoardNames {
+		bkt, _ := s.boardLocked(name)
+		items := s.expandLocked(bkt.TopK(s.config.SSETopK), true)
+		entries := make([]BoardEntry, len(items))
+		for i, it := range items {
+			entries[i] = BoardEntry{DocID: it.DocID, Rank: i + 1, Clicks: it.Clicks, Title: it.Title, URL: it.URL, Category: it.Meta[metaCategory]}
+		}
+		boards[name] = entries
+		if ch := diffBoard(s.pushed.Boards[name], entries); len(ch.Upsert) > 0 || len(ch.Remove) > 0 {
+			changed = true
+		}
+	}
+	if !changed {
 		return
 	}
-	s.lastPush = now
-	// 广播点击更新
-	s.sse.BroadcastUpdateClick()
+	// 版本号取毫秒时间戳并保证递增，重启后仍可与旧版本区分
+	v := uint64(time.Now().UnixMilli())
+	if v <= s.pushed.Version {
+		v = s.pushed.Version + 1
+	}
+	if s.hooks != nil && s.pushed.Boards != nil {
+		s.hooks.boards(s.pushed.Boards, boards)
+	}
+	s.pushed = BoardState{Version: v, Boards: boards}
+	s.sse.PublishBoard(s.pushed)
+}
+
+// takeRankSnapshot 记录各榜单前若干项的名次与计数
+func (s *Store) takeRankSnapshot(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	boards := make(map[string]map[string]rankPos, len(rankBoardNames))
+	for _, name := range rankBoardNames {
+		b, _ := s.boardLocked(name)
+		items := b.TopK(s.config.RankSnapshotDepth)
+		m := make(map[string]rankPos, len(items))
+		for i, it := range items {
+			m[it.DocID] = rankPos{Rank: i + 1, Clicks: it.Clicks}
+		}
+		boards[name] = m
+	}
+	s.hist.Add(now, boards)
+}
+
+// StartRankSnapshotter 启动周期性榜单快照
+func (s *Store) StartRankSnapshotter(stop <-chan struct{}) {
+	s.takeRankSnapshot(time.Now())
+	ticker := time.NewTicker(s.config.RankSnapshotInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.takeRankSnapshot(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StartRankArchiver 启动历史榜单归档，按固定间隔写入快照并每小时压缩一次
+func (s *Store) StartRankArchiver(arc *RankArchive, stop <-chan struct{}) {
+	s.mu.Lock()
+	s.arc = arc
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(s.config.ArchiveInterval)
+	go func() {
+		defer ticker.Stop()
+		lastCompact := time.Time{}
+		for {
+			select {
+			case now := <-ticker.C:
+				s.mu.RLock()
+				snap := archiveSnapshot{At: now.Unix(), Boards: make(map[string][]RankItem, len(rankBoardNames))}
+				for _, name := range rankBoardNames {
+					b, _ := s.boardLocked(name)
+					snap.Boards[name] = b.TopK(s.config.ArchiveTopK)
+				}
+				s.mu.RUnlock()
+				if err := arc.Append(snap); err != nil {
+					log.Printf("archive append error: %v", err)
+				}
+				if now.Sub(lastCompact) >= time.Hour {
+					lastCompact = now
+					if n, err := arc.Compact(now); err != nil {
+						log.Printf("archive compact error: %v", err)
+					} else if n > 0 {
+						log.Printf("archive compacted: dropped=%d kept=%d", n, arc.Len())
+					}
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
 }
 
 // StartRecentAdvancer 启动定时推进最近窗口
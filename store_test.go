@@ -0,0 +1,75 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// newTestStore 在临时目录中创建已装载的空 Store
+func newTestStore(t *testing.T, cfg Config) *Store {
+	t.Helper()
+	p, err := NewPersist(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sse := NewSSEHub(defaultNamespace, NewMemoryBroker("test"), cfg)
+	s := NewStore(p, sse, cfg)
+	s.Load(&RestoreState{Counts: map[string]int{}})
+	t.Cleanup(func() {
+		s.StopPush()
+		sse.Close()
+		_ = p.Close()
+	})
+	return s
+}
+
+func TestRankExportIsSnapshot(t *testing.T) {
+	s := newTestStore(t, LoadConfig())
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.AddOrUpdateDoc(Doc{ID: id, Title: "T" + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	click := func(id string, n int) {
+		for range n {
+			if _, _, err := s.Click(ClickEvent{DocID: id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	click("a", 1)
+	click("b", 3)
+	click("c", 2)
+
+	ranked, ok := s.RankExport("total", 0)
+	if !ok {
+		t.Fatal("total board not found")
+	}
+	// 导出开始后的点击与删除不影响已复制的顺序与计数
+	click("a", 10)
+	if err := s.DeleteDoc("c"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		start, end int
+		want       []RankExportItem
+	}{
+		{0, 2, []RankExportItem{{Rank: 1, DocID: "b", Title: "Tb", Clicks: 3}, {Rank: 2, DocID: "c", Clicks: 2}}},
+		{2, 3, []RankExportItem{{Rank: 3, DocID: "a", Title: "Ta", Clicks: 1}}},
+	}
+	for _, tt := range tests {
+		got := s.RankExportRows(ranked[tt.start:tt.end], tt.start)
+		if !slices.EqualFunc(got, tt.want, func(x, y RankExportItem) bool {
+			return x.Rank == y.Rank && x.DocID == y.DocID && x.Title == y.Title && x.Clicks == y.Clicks
+		}) {
+			t.Errorf("rows [%d:%d] = %+v, want %+v", tt.start, tt.end, got, tt.want)
+		}
+	}
+
+	if ranked, _ := s.RankExport("total", 1); len(ranked) != 1 || ranked[0].DocID != "a" {
+		t.Errorf("RankExport limit 1 = %+v, want a first", ranked)
+	}
+	if _, ok := s.RankExport("weekly", 0); ok {
+		t.Error("unknown board should not be found")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 时间序列最多返回的点数
+const tsMaxPoints = 10000
+
+var (
+	errTooManyPoints = errors.New("too many points, use a larger step")
+	errTSRetention   = errors.New("range is beyond the retention of this step, use a larger step")
+)
+
+// TSPoint 为时间序列中的一个点，Ts 为桶起始秒级时间戳
+type TSPoint struct {
+	Ts     int64 `json:"ts"`
+	Clicks int   `json:"clicks"`
+}
+
+// tsResolution 是某一粒度的点击汇总：docID -> 桶起始秒 -> 点击数
+type tsResolution struct {
+	name      string
+	step      int64
+	retention int64
+	data      map[string]map[int64]int
+}
+
+// TimeSeries 将点击按分钟、小时、天汇总，各粒度独立保留
+// 与 Buckets 一样不自带锁，由 Store 的锁保护
+type TimeSeries struct {
+	res []*tsResolution // 按粒度从细到粗
+}
+
+// timeSeriesModel 为持久化格式，Seq 为保存时 WAL 的序号
+type timeSeriesModel struct {
+	Seq    uint64                              `json:"seq"`
+	Series map[string]map[string]map[int64]int `json:"series"`
+}
+
+// NewTimeSeries 创建分钟、小时、天三级汇总
+func NewTimeSeries(cfg Config) *TimeSeries {
+	mk := func(name string, step int64, retention time.Duration) *tsResolution {
+		return &tsResolution{
+			name:      name,
+			step:      step,
+			retention: int64(retention / time.Second),
+			data:      make(map[string]map[int64]int),
+		}
+	}
+	return &TimeSeries{res: []*tsResolution{
+		mk("minute", 60, cfg.TSMinuteRetention),
+		mk("hour", 3600, cfg.TSHourRetention),
+		mk("day", 86400, cfg.TSDayRetention),
+	}}
+}
+
+// Add 记录 docID 在 ts 秒的 n 次点击
+func (t *TimeSeries) Add(docID string, ts int64, n int) {
+	for _, r := range t.res {
+		m := r.data[docID]
+		if m == nil {
+			m = make(map[int64]int)
+			r.data[docID] = m
+		}
+		m[ts-ts%r.step] += n
+	}
+}
+
+// Delete 删除 docID 的全部时间序列
+func (t *TimeSeries) Delete(docID string) {
+	for _, r := range t.res {
+		delete(r.data, docID)
+	}
+}
+
+// Prune 清理各粒度中超出保留期的桶
+func (t *TimeSeries) Prune(now int64) {
+	for _, r := range t.res {
+		cutoff := now - r.retention
+		for id, m := range r.data {
+			for start := range m {
+				if start+r.step <= cutoff {
+					delete(m, start)
+				}
+			}
+			if len(m) == 0 {
+				delete(r.data, id)
+			}
+		}
+	}
+}
+
+// pick 选择能整除 step 的最粗粒度
+func (t *TimeSeries) pick(step int64) *tsResolution {
+	var best *tsResolution
+	for _, r := range t.res {
+		if r.step <= step && step%r.step == 0 {
+			best = r
+		}
+	}
+	return best
+}
+
+// Range 返回 [from, to) 内按 step 聚合的点，起点按 step 对齐，缺失的桶补 0
+// 所选粒度已清理 from 所在的桶时返回错误，而不是以 0 填充
+func (t *TimeSeries) Range(docID string, from, to, step, now int64) ([]TSPoint, string, error) {
+	r := t.pick(step)
+	if r == nil {
+		return nil, "", errors.New("step must be a multiple of 1m")
+	}
+	if from-from%r.step+r.step <= now-r.retention {
+		return nil, "", errTSRetention
+	}
+	start := from - from%step
+	if (to-start+step-1)/step > tsMaxPoints {
+		return nil, "", errTooManyPoints
+	}
+	points := make([]TSPoint, 0, (to-start+step-1)/step)
+	for ts := start; ts < to; ts += step {
+		points = append(points, TSPoint{Ts: ts})
+	}
+	for bs, n := range r.data[docID] {
+		if bs < start || bs >= to {
+			continue
+		}
+		points[(bs-start)/step].Clicks += n
+	}
+	return points, r.name, nil
+}
+
+// model 复制出持久化数据，调用方可在释放锁后编码
+func (t *TimeSeries) model(seq uint64) timeSeriesModel {
+	m := timeSeriesModel{Seq: seq, Series: make(map[string]map[string]map[int64]int, len(t.res))}
+	for _, r := range t.res {
+		data := make(map[string]map[int64]int, len(r.data))
+		for id, buckets := range r.data {
+			data[id] = maps.Clone(buckets)
+		}
+		m.Series[r.name] = data
+	}
+	return m
+}
+
+// restore 从持久化数据恢复，未知粒度忽略
+func (t *TimeSeries) restore(m *timeSeriesModel) {
+	for _, r := range t.res {
+		if data, ok := m.Series[r.name]; ok && data != nil {
+			r.data = data
+		}
+	}
+}
+
+// tsSegment 表示一次桶读取，weight < 1 时为按比例估算的部分桶
+type tsSegment struct {
+	r      *tsResolution
+	start  int64
+	weight float64
+}
+
+// plan 将 [from, to) 拆分为尽量粗的对齐桶：中间用天，两端依次退到小时、分钟
+// 边缘若不足一个分钟桶，或更细粒度已超出保留期，则按重叠时长占比估算，此时 approx 为 true
+func (t *TimeSeries) plan(from, to, now int64) (segs []tsSegment, approx bool) {
+	// 当前时刻之后不会有点击
+	if to > now+1 {
+		to = now + 1
+	}
+	approx = t.planLevel(len(t.res)-1, from, to, now, &segs)
+	return segs, approx
+}
+
+func (t *TimeSeries) planLevel(i int, from, to, now int64, segs *[]tsSegment) bool {
+	if from >= to {
+		return false
+	}
+	r := t.res[i]
+	a := ceilTo(from, r.step)
+	b := to - to%r.step
+	if a > b {
+		// 区间落在单个桶内，整体作为残余处理
+		return t.planResidual(i, from, to, now, segs)
+	}
+	for s := a; s < b; s += r.step {
+		*segs = append(*segs, tsSegment{r: r, start: s, weight: 1})
+	}
+	left := t.planResidual(i, from, a, now, segs)
+	right := t.planResidual(i, b, to, now, segs)
+	return left || right
+}
+
+// planResidual 处理不足一个桶的残余区间 [from, to)
+func (t *TimeSeries) planResidual(i int, from, to, now int64, segs *[]tsSegment) bool {
+	if from >= to {
+		return false
+	}
+	if i > 0 {
+		finer := t.res[i-1]
+		c := ceilTo(now-finer.retention, finer.step)
+		if from >= c {
+			return t.planLevel(i-1, from, to, now, segs)
+		}
+		if c < to {
+			// 残余区间跨越更细粒度的保留边界：边界之后用细粒度，之前按比例估算
+			t.planLevel(i-1, c, to, now, segs)
+			to = c
+		}
+	}
+	r := t.res[i]
+	start := from - from%r.step
+	// 当前进行中的桶只统计到 now
+	end := min(start+r.step, now+1)
+	if from <= start && to >= end {
+		*segs = append(*segs, tsSegment{r: r, start: start, weight: 1})
+		return false
+	}
+	*segs = append(*segs, tsSegment{r: r, start: start, weight: float64(to-from) / float64(end-start)})
+	return true
+}
+
+func ceilTo(v, step int64) int64 {
+	if rem := v % step; rem != 0 {
+		return v + step - rem
+	}
+	return v
+}
+
+// RangeCounts 返回 [from, to) 内各文档的点击数 (不含 0)，以及结果是否含估算
+func (t *TimeSeries) RangeCounts(from, to, now int64) (map[string]int, bool) {
+	segs, approx := t.plan(from, to, now)
+	sums := make(map[string]float64)
+	for _, seg := range segs {
+		for id, m := range seg.r.data {
+			if n, ok := m[seg.start]; ok && n > 0 {
+				sums[id] += float64(n) * seg.weight
+			}
+		}
+	}
+	out := make(map[string]int, len(sums))
+	for id, v := range sums {
+		if n := int(math.Round(v)); n > 0 {
+			out[id] = n
+		}
+	}
+	return out, approx
+}
+
+// RangeTopK 返回 [from, to) 内点击最多的前 k 项，以及结果是否含估算
+func (t *TimeSeries) RangeTopK(from, to, now int64, k int) ([]RankItem, bool) {
+	counts, approx := t.RangeCounts(from, to, now)
+	items := make([]RankItem, 0, len(counts))
+	for id, n := range counts {
+		items = append(items, RankItem{DocID: id, Clicks: n})
+	}
+	slices.SortFunc(items, func(p, q RankItem) int {
+		if p.Clicks != q.Clicks {
+			return q.Clicks - p.Clicks
+		}
+		return strings.Compare(p.DocID, q.DocID)
+	})
+	if k >= 0 && len(items) > k {
+		items = items[:k]
+	}
+	return items, approx
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// tsDay 为按天对齐的时间戳，测试中的时刻都相对它给出
+const tsDay int64 = 1_700_006_400
+
+func newTestTimeSeries() *TimeSeries {
+	return NewTimeSeries(Config{
+		TSMinuteRetention: 24 * time.Hour,
+		TSHourRetention:   720 * time.Hour,
+		TSDayRetention:    8760 * time.Hour,
+	})
+}
+
+func TestTimeSeriesRange(t *testing.T) {
+	now := tsDay + 12*3600
+	ts := newTestTimeSeries()
+	for _, c := range []int64{now - 90, now - 80, now - 200, now - 2*3600, now - 30*3600} {
+		ts.Add("a", c, 1)
+	}
+	ts.Add("b", now-90, 5)
+
+	tests := []struct {
+		name     string
+		from, to int64
+		step     int64
+		res      string
+		points   int
+		clicks   []TSPoint // 需核对的点
+		err      error
+		anyErr   bool
+	}{
+		{
+			name: "minute buckets", from: now - 300, to: now, step: 60, res: "minute", points: 5,
+			clicks: []TSPoint{{Ts: now - 120, Clicks: 2}, {Ts: now - 240, Clicks: 1}, {Ts: now - 60, Clicks: 0}},
+		},
+		{
+			name: "unaligned from starts at the enclosing bucket", from: now - 250, to: now, step: 60, res: "minute", points: 5,
+			clicks: []TSPoint{{Ts: now - 300, Clicks: 0}, {Ts: now - 240, Clicks: 1}},
+		},
+		{
+			name: "two-minute step sums minute buckets", from: now - 240, to: now, step: 120, res: "minute", points: 2,
+			clicks: []TSPoint{{Ts: now - 240, Clicks: 1}, {Ts: now - 120, Clicks: 2}},
+		},
+		{
+			name: "hour step uses hour buckets", from: now - 3*3600, to: now, step: 3600, res: "hour", points: 3,
+			clicks: []TSPoint{{Ts: now - 2*3600, Clicks: 1}, {Ts: now - 3600, Clicks: 3}},
+		},
+		{
+			name: "day step beyond minute retention", from: tsDay - 86400, to: tsDay + 86400, step: 86400, res: "day", points: 2,
+			clicks: []TSPoint{{Ts: tsDay - 86400, Clicks: 1}, {Ts: tsDay, Clicks: 4}},
+		},
+		{name: "step not a multiple of a minute", from: now - 300, to: now, step: 90, anyErr: true},
+		{name: "step below a minute", from: now - 300, to: now, step: 30, anyErr: true},
+		{name: "minute range past retention", from: now - 25*3600, to: now, step: 60, err: errTSRetention},
+		{name: "too many points", from: now - 3600, to: now + 1_000_000, step: 60, err: errTooManyPoints},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, res, err := ts.Range("a", tt.from, tt.to, tt.step, now)
+			if tt.err != nil || tt.anyErr {
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res != tt.res {
+				t.Errorf("resolution = %q, want %q", res, tt.res)
+			}
+			if len(points) != tt.points {
+				t.Fatalf("got %d points, want %d", len(points), tt.points)
+			}
+			for _, want := range tt.clicks {
+				found := false
+				for _, p := range points {
+					if p.Ts == want.Ts {
+						found = true
+						if p.Clicks != want.Clicks {
+							t.Errorf("clicks at %d = %d, want %d", p.Ts-now, p.Clicks, want.Clicks)
+						}
+					}
+				}
+				if !found {
+					t.Errorf("no point at %d", want.Ts-now)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeSeriesPrune(t *testing.T) {
+	now := tsDay + 12*3600
+	ts := newTestTimeSeries()
+	ts.Add("a", now-25*3600, 1)
+	ts.Add("a", now-60, 1)
+	ts.Prune(now)
+
+	// 分钟粒度只保留 24 小时，更粗的粒度仍有两次点击
+	if points, _, err := ts.Range("a", now-3600, now, 3600, now); err != nil || points[0].Clicks != 1 {
+		t.Errorf("minute-retained range = %+v, %v", points, err)
+	}
+	if n := len(ts.res[0].data["a"]); n != 1 {
+		t.Errorf("minute buckets after prune = %d, want 1", n)
+	}
+	counts, _ := ts.RangeCounts(now-26*3600, now+1, now)
+	if counts["a"] != 2 {
+		t.Errorf("hour/day counts after prune = %d, want 2", counts["a"])
+	}
+
+	ts.Delete("a")
+	for _, r := range ts.res {
+		if _, ok := r.data["a"]; ok {
+			t.Errorf("%s data not deleted", r.name)
+		}
+	}
+}
+
+func TestTimeSeriesRangeCounts(t *testing.T) {
+	now := tsDay + 12*3600
+	tests := []struct {
+		name     string
+		clicks   []int64
+		from, to int64
+		want     int
+		approx   bool
+	}{
+		{
+			name:   "hours inside the current day",
+			clicks: []int64{tsDay - 3600, tsDay + 3600, tsDay + 11*3600},
+			from:   tsDay, to: now, want: 2,
+		},
+		{
+			name:   "partial minute at the right edge is estimated",
+			clicks: []int64{tsDay + 10, tsDay + 70, tsDay + 100},
+			from:   tsDay, to: tsDay + 90, want: 2, approx: true,
+		},
+		{
+			name:   "whole days then hours",
+			clicks: []int64{tsDay - 3*86400 + 5*3600, tsDay - 86400 + 23*3600, tsDay + 11*3600, tsDay - 4*86400},
+			from:   tsDay - 3*86400, to: now, want: 3,
+		},
+		{
+			name:   "partial hour past minute retention is estimated",
+			clicks: []int64{tsDay - 2*86400 + 600, tsDay - 2*86400 + 2400, tsDay - 86400 - 60},
+			from:   tsDay - 2*86400 + 1800, to: tsDay - 86400, want: 2, approx: true,
+		},
+		{
+			name:   "partial hour within minute retention uses minutes",
+			clicks: []int64{now - 3600 + 600, now - 3600 + 2400},
+			from:   now - 3600 + 1800, to: now, want: 1,
+		},
+		{
+			name:   "future end is clamped to now",
+			clicks: []int64{tsDay + 3600, now},
+			from:   tsDay, to: tsDay + 2*86400, want: 2,
+		},
+		{
+			name:   "empty range",
+			clicks: []int64{tsDay},
+			from:   tsDay + 10, to: tsDay + 10, want: 0,
+		},
+		{
+			name:   "range within a single minute",
+			clicks: []int64{tsDay + 70, tsDay + 80},
+			from:   tsDay + 60, to: tsDay + 90, want: 1, approx: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestTimeSeries()
+			for _, c := range tt.clicks {
+				ts.Add("a", c, 1)
+			}
+			counts, approx := ts.RangeCounts(tt.from, tt.to, now)
+			if counts["a"] != tt.want || approx != tt.approx {
+				t.Errorf("RangeCounts = %d (approx %v), want %d (approx %v)", counts["a"], approx, tt.want, tt.approx)
+			}
+		})
+	}
+}
+
+func TestTimeSeriesPlanCoversRangeOnce(t *testing.T) {
+	now := tsDay + 12*3600 + 1234
+	ts := newTestTimeSeries()
+	ranges := [][2]int64{
+		{tsDay - 10*86400 + 123, now},
+		{tsDay - 3*86400, tsDay - 86400 + 3599},
+		{now - 7200 - 59, now - 61},
+		{tsDay + 60, tsDay + 3600*5},
+	}
+	for _, rg := range ranges {
+		segs, approx := ts.plan(rg[0], rg[1], now)
+		// 各段按权重折算的时长之和应等于区间长度，且同一桶不重复读取
+		var covered float64
+		seen := make(map[[2]int64]bool)
+		for _, s := range segs {
+			key := [2]int64{s.r.step, s.start}
+			if seen[key] {
+				t.Errorf("range %v reads %s bucket %d twice", rg, s.r.name, s.start)
+			}
+			seen[key] = true
+			end := min(s.start+s.r.step, now+1)
+			covered += s.weight * float64(end-s.start)
+		}
+		if want := float64(min(rg[1], now+1) - rg[0]); covered < want-1e-6 || covered > want+1e-6 {
+			t.Errorf("range %v covers %.1fs, want %.0fs (approx %v)", rg, covered, want, approx)
+		}
+	}
+}
+
+func TestTimeSeriesRangeTopK(t *testing.T) {
+	now := tsDay + 12*3600
+	ts := newTestTimeSeries()
+	for id, n := range map[string]int{"a": 1, "b": 3, "c": 3, "d": 2} {
+		ts.Add(id, now-600, n)
+	}
+	items, approx := ts.RangeTopK(now-3600, now, now, 3)
+	if approx {
+		t.Error("aligned range should be exact")
+	}
+	if got, want := rankString(items), []string{"b:3", "c:3", "d:2"}; !slices.Equal(got, want) {
+		t.Errorf("RangeTopK = %v, want %v", got, want)
+	}
+}
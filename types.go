@@ -1,32 +1,91 @@
 package main
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Doc struct {
-	ID    string `json:"id"`
-	Title string `json:"title"`
-	URL   string `json:"url"`
+	ID    string            `json:"id"`
+	Title string            `json:"title"`
+	URL   string            `json:"url"`
+	Meta  map[string]string `json:"meta,omitempty"`
 }
 
 type ClickReq struct {
 	DocID string `json:"doc_id" binding:"required"`
+	ClickSource
+	// 自动登记未知文档时使用
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	// 开启点击令牌时必填
+	Token string `json:"token,omitempty"`
+}
+
+// ClickEvent 描述一次点击
+type ClickEvent struct {
+	DocID  string
+	Source ClickSource
+	// 自动登记时用作占位文档的标题与链接
+	Title string
+	URL   string
+	// 供点击过滤器使用，不落盘；ClientIP 按可信代理解析，Visitor 由客户端自报
+	ClientIP  string
+	Visitor   string
+	UserAgent string
 }
 
 type RankItem struct {
 	DocID  string `json:"doc_id"`
 	Clicks int    `json:"clicks"`
+	// 以下字段仅在 expand 时填充
+	Title string            `json:"title,omitempty"`
+	URL   string            `json:"url,omitempty"`
+	Meta  map[string]string `json:"meta,omitempty"`
+	// 以下字段仅在指定对比基准时填充
+	PrevRank   int  `json:"prev_rank,omitempty"`   // 基准中的名次
+	RankChange int  `json:"rank_change,omitempty"` // 正数表示上升
+	ClickDelta int  `json:"click_delta,omitempty"` // 相对基准的计数变化
+	New        bool `json:"new,omitempty"`         // 基准中不存在
+	// 近似榜单中计数的最大高估量
+	Error int `json:"error,omitempty"`
 }
 
 type RankResp struct {
 	Rank []RankItem `json:"rank"`
+	// 最新榜单快照版本，可作为下次请求的 since_version；与 SSE 的 stream_version 无关，不可互换
+	Version     uint64 `json:"version,omitempty"`
+	BaseVersion uint64 `json:"base_version,omitempty"`
+	BaseAt      int64  `json:"base_at,omitempty"` // 基准快照的秒级时间戳
+	// 历史查询时为所用归档快照的秒级时间戳
+	At int64 `json:"at,omitempty"`
+	// 区间查询的秒级起止时间，Approximate 表示边缘按比例估算
+	From        int64 `json:"from,omitempty"`
+	To          int64 `json:"to,omitempty"`
+	Approximate bool  `json:"approximate,omitempty"`
+	// 近似榜单的误差界
+	Approx *ApproxInfo `json:"approx,omitempty"`
 }
 
 type DocsResp struct {
 	Documents []Doc `json:"documents"`
+	// 开启点击令牌时，为每个文档签发的令牌
+	ClickTokens map[string]string `json:"click_tokens,omitempty"`
+}
+
+// ClickTokenResp 为签发的点击令牌
+type ClickTokenResp struct {
+	DocID     string `json:"doc_id"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"` // 秒级时间戳
+	GoURL     string `json:"go_url"`
 }
 
 type UpsertDocReq struct {
-	ID    string `json:"id" binding:"required"`
-	Title string `json:"title"`
-	URL   string `json:"url"`
+	ID    string            `json:"id" binding:"required"`
+	Title string            `json:"title"`
+	URL   string            `json:"url"`
+	Meta  map[string]string `json:"meta"`
 }
 
 // walEntry 表示 WAL 条目
@@ -37,4 +96,187 @@ type walEntry struct {
 	Title string `json:"title,omitempty"`
 	URL   string `json:"url,omitempty"`
 	Ts    int64  `json:"ts,omitempty"` // CLICK 的秒级时间戳 (Unix)
+
+	Meta map[string]string `json:"meta,omitempty"`
+	Src  *ClickSource      `json:"src,omitempty"` // CLICK 的来源
+}
+
+// BulkRowError 表示批量导入中某一行的错误，Row 从 1 开始
+type BulkRowError struct {
+	Row     int    `json:"row"`
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// BulkResp 为批量导入结果
+type BulkResp struct {
+	DryRun  bool           `json:"dry_run"`
+	Total   int            `json:"total"`
+	Added   int            `json:"added"`
+	Updated int            `json:"updated"`
+	Failed  int            `json:"failed"`
+	Errors  []BulkRowError `json:"errors"`
+}
+
+// DocExportItem 为导出的文档行，附带当前计数
+type DocExportItem struct {
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	URL          string `json:"url"`
+	Clicks       int    `json:"clicks"`
+	RecentClicks int    `json:"recent_clicks"`
+
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// RankExportItem 为榜单导出行，附带文档标题与链接
+type RankExportItem struct {
+	Rank   int    `json:"rank"`
+	DocID  string `json:"doc_id"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	Clicks int    `json:"clicks"`
+
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// TimeRange 为秒级时间区间 [From, To)
+type TimeRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// CompareItem 为某文档在两个区间的点击对比
+type CompareItem struct {
+	DocID     string   `json:"doc_id"`
+	Current   int      `json:"current"`
+	Previous  int      `json:"previous"`
+	Change    int      `json:"change"`
+	ChangePct *float64 `json:"change_pct"` // 上期为 0 时为 null
+
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// CompareResp 为区间对比报告
+type CompareResp struct {
+	Current     TimeRange     `json:"current"`
+	Previous    TimeRange     `json:"previous"`
+	Approximate bool          `json:"approximate"`
+	Items       []CompareItem `json:"items"`
+	Risers      []CompareItem `json:"risers"`
+	Fallers     []CompareItem `json:"fallers"`
+}
+
+// CreateNamespaceReq 为创建命名空间请求
+type CreateNamespaceReq struct {
+	Name    string `json:"name" binding:"required"`
+	MaxDocs int    `json:"max_docs"`
+}
+
+// QuarantineResp 为隔离区视图
+type QuarantineResp struct {
+	Stats       FilterStats     `json:"stats"`
+	Quarantined []FilteredClick `json:"quarantined"`
+	Flagged     []FilteredClick `json:"flagged"`
+}
+
+// QuarantineReviewReq 为批量审核请求
+type QuarantineReviewReq struct {
+	IDs []string `json:"ids" binding:"required"`
+}
+
+// QuarantineReviewItem 为单条审核结果
+type QuarantineReviewItem struct {
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// BoardEntry 为 SSE 推送的榜单条目
+type BoardEntry struct {
+	DocID    string `json:"doc_id"`
+	Rank     int    `json:"rank"` // 从 1 开始
+	Clicks   int    `json:"clicks"`
+	Title    string `json:"title,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Category string `json:"category,omitempty"` // 文档元数据中的 category
+}
+
+// BoardState 为某版本的完整榜单
+// stream_version 只用于 SSE/WebSocket 推送中差异的衔接，由各 hub 自行递增，与 /rank 的 version 不是同一序列
+type BoardState struct {
+	Version uint64                  `json:"stream_version"`
+	Boards  map[string][]BoardEntry `json:"boards"`
+}
+
+// BoardChange 为单个榜单的变化：upsert 为新进入或名次、计数、标题变化的条目，remove 为跌出的文档
+type BoardChange struct {
+	Upsert []BoardEntry `json:"upsert,omitempty"`
+	Remove []string     `json:"remove,omitempty"`
+}
+
+// BoardDiff 为相对 base_stream_version 的榜单差异，客户端版本不一致时应重新获取完整榜单
+type BoardDiff struct {
+	Version     uint64                 `json:"stream_version"`
+	BaseVersion uint64                 `json:"base_stream_version"`
+	Boards      map[string]BoardChange `json:"boards"`
+}
+
+// DocEvent 为文档变更事件，op 为 upsert 或 delete (delete 时 docs 只含 id)
+type DocEvent struct {
+	Op   string `json:"op"`
+	Docs []Doc  `json:"docs"`
+}
+
+// CreateWebhookReq 为登记回调的请求，字段含义见 Webhook
+type CreateWebhookReq struct {
+	URL        string   `json:"url" binding:"required"`
+	Secret     string   `json:"secret"`
+	Events     []string `json:"events"`
+	Board      string   `json:"board"`
+	TopN       int      `json:"top_n"`
+	Milestones []int    `json:"milestones"`
+	Docs       []string `json:"docs"`
+	Categories []string `json:"categories"`
+}
+
+// CreateAlertReq 为创建告警规则的请求，字段含义见 AlertRule
+type CreateAlertReq struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind" binding:"required"`
+	Board    string `json:"board"`
+	DocID    string `json:"doc_id"`
+	Op       string `json:"op"`
+	Value    int    `json:"value"`
+	TopN     int    `json:"top_n"`
+	Window   string `json:"window"`
+	Cooldown string `json:"cooldown"`
+}
+
+// AlertEvent 为告警规则的状态变化，SSE 事件 alert 的数据
+type AlertEvent struct {
+	ID      string    `json:"id"`
+	RuleID  string    `json:"rule_id"`
+	Name    string    `json:"name,omitempty"`
+	Kind    string    `json:"kind"`
+	State   string    `json:"state"` // firing 或 resolved
+	DocID   string    `json:"doc_id,omitempty"`
+	Value   int       `json:"value"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// WSMessage 为 WebSocket 下发的消息：event 与 data 同 SSE 事件，另有 click 与 error 应答
+type WSMessage struct {
+	ID    string          `json:"id,omitempty"` // 事件 ID，重连时作为 last_event_id
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// WSRequest 为 WebSocket 客户端发来的消息，type 目前只有 click；req_id 原样带回应答
+type WSRequest struct {
+	Type  string `json:"type"`
+	ReqID string `json:"req_id,omitempty"`
+	ClickReq
 }
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// webhook 事件类型
+const (
+	hookTopEnter  = "top_enter"  // 文档进入榜单前 N
+	hookTopLeave  = "top_leave"  // 文档跌出榜单前 N (含被删除)
+	hookMilestone = "milestone"  // 总点击数达到里程碑
+	hookDocDelete = "doc_delete" // 文档被删除
+	hookPing      = "ping"       // 手动测试，总是发送
+)
+
+var webhookEventTypes = []string{hookTopEnter, hookTopLeave, hookMilestone, hookDocDelete}
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrWebhookLimit    = errors.New("webhook limit reached")
+	ErrWebhookInvalid  = errors.New("invalid webhook")
+	ErrWebhookBlocked  = errors.New("webhook destination not allowed")
+)
+
+// Webhook 为登记的回调地址及其订阅条件，为空的条件不限制
+type Webhook struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"` // 列表中不返回
+	Events     []string  `json:"events,omitempty"`
+	Board      string    `json:"board"` // top_enter、top_leave 所看的榜单
+	TopN       int       `json:"top_n"`
+	Milestones []int     `json:"milestones,omitempty"`
+	Docs       []string  `json:"docs,omitempty"`
+	Categories []string  `json:"categories,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// wants 判断回调是否订阅该事件
+func (h *Webhook) wants(ev *WebhookEvent) bool {
+	if ev.Type == hookPing {
+		return true
+	}
+	if len(h.Events) > 0 && !slices.Contains(h.Events, ev.Type) {
+		return false
+	}
+	if len(h.Docs) > 0 && !slices.Contains(h.Docs, ev.DocID) {
+		return false
+	}
+	return len(h.Categories) == 0 || slices.Contains(h.Categories, ev.Category)
+}
+
+// WebhookEvent 为回调请求体
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Namespace string    `json:"namespace"`
+	Time      time.Time `json:"time"`
+	DocID     string    `json:"doc_id,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Category  string    `json:"category,omitempty"`
+	Board     string    `json:"board,omitempty"`
+	Rank      int       `json:"rank,omitempty"` // top_enter 时的名次
+	TopN      int       `json:"top_n,omitempty"`
+	Clicks    int       `json:"clicks,omitempty"`
+	Milestone int       `json:"milestone,omitempty"`
+}
+
+// webhookDelivery 为待投递 (含等待重试) 的回调
+type webhookDelivery struct {
+	ID       string       `json:"id"`
+	HookID   string       `json:"hook_id"`
+	Event    WebhookEvent `json:"event"`
+	Attempts int          `json:"attempts"`
+	NextAt   time.Time    `json:"next_at"`
+	inflight bool
+}
+
+// 投递结果
+const (
+	deliveryOK      = "delivered"
+	deliveryRetry   = "retry"
+	deliveryFailed  = "failed"  // 重试次数用尽
+	deliveryDropped = "dropped" // 队列已满
+)
+
+// WebhookLogEntry 为一次投递尝试的记录
+type WebhookLogEntry struct {
+	DeliveryID string    `json:"delivery_id"`
+	HookID     string    `json:"hook_id"`
+	EventID    string    `json:"event_id"`
+	Type       string    `json:"type"`
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	Status     int       `json:"status,omitempty"` // HTTP 状态码
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	Result     string    `json:"result"`
+	NextAt     time.Time `json:"next_at,omitzero"`
+}
+
+// webhooksFile 为落盘格式：回调、待投递队列与投递记录
+type webhooksFile struct {
+	Hooks   []*Webhook         `json:"hooks"`
+	Pending []*webhookDelivery `json:"pending"`
+	Log     []WebhookLogEntry  `json:"log"`
+}
+
+// Webhooks 管理命名空间的回调：由 Store 的排行事件触发，签名后异步投递，失败按指数退避重试
+// 回调、待投递队列与投递记录保存在命名空间目录，重启后继续重试
+type Webhooks struct {
+	mu      sync.Mutex
+	ns      string
+	path    string
+	hooks   []*Webhook
+	pending []*webhookDelivery
+	log     []WebhookLogEntry
+	dirty   bool
+
+	client      *http.Client
+	allowPriv   bool
+	maxHooks    int
+	maxTopN     int
+	maxAttempts int
+	backoff     time.Duration
+	backoffMax  time.Duration
+	queueMax    int
+	logSize     int
+
+	sem    chan struct{} // 并发投递上限
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // 进行中的投递
+	loop   sync.WaitGroup // Start 启动的调度循环
+}
+
+// NewWebhooks 创建并从 path 恢复命名空间 ns 的回调
+func NewWebhooks(ns, path string, cfg Config) (*Webhooks, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Webhooks{
+		ns:          ns,
+		path:        path,
+		client:      newWebhookClient(cfg),
+		allowPriv:   cfg.WebhookAllowPrivate,
+		maxHooks:    cfg.WebhookMaxHooks,
+		maxTopN:     cfg.SSETopK,
+		maxAttempts: cfg.WebhookMaxAttempts,
+		backoff:     cfg.WebhookBackoff,
+		backoffMax:  cfg.WebhookBackoffMax,
+		queueMax:    cfg.WebhookQueueMax,
+		logSize:     cfg.WebhookLogSize,
+		sem:         make(chan struct{}, cfg.WebhookConcurrency),
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f webhooksFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	w.hooks, w.pending, w.log = f.Hooks, f.Pending, f.Log
+	return w, nil
+}
+
+// newWebhookClient 创建投递用的 HTTP 客户端
+// 地址检查在拨号时针对解析后的 IP 进行，重定向与 DNS 重绑定同样受限；不经环境变量中的代理，以免绕过检查
+func newWebhookClient(cfg Config) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.WebhookTimeout}
+	if !cfg.WebhookAllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip, err := netip.ParseAddr(host); err != nil || blockedAddr(ip) {
+				return fmt.Errorf("%w: %s", ErrWebhookBlocked, host)
+			}
+			return nil
+		}
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = nil
+	tr.DialContext = dialer.DialContext
+	return &http.Client{Timeout: cfg.WebhookTimeout, Transport: tr}
+}
+
+// sharedAddrSpace 为运营商级 NAT 地址段，部分云厂商的元数据服务位于其中
+var sharedAddrSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// blockedAddr 判断是否为回环、内网、链路本地、组播或未指定地址
+func blockedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddrSpace.Contains(ip)
+}
+
+// randomID 生成随机十六进制 ID
+func randomID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Create 登记回调，未给出签名密钥时自动生成
+func (w *Webhooks) Create(req CreateWebhookReq) (Webhook, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("%w: url must be an absolute http(s) url", ErrWebhookInvalid)
+	}
+	// 域名在投递时按解析结果检查，这里只提前拒绝 IP 字面量与 localhost
+	if !w.allowPriv {
+		host := u.Hostname()
+		ip, err := netip.ParseAddr(host)
+		if strings.EqualFold(host, "localhost") || (err == nil && blockedAddr(ip)) {
+			return Webhook{}, fmt.Errorf("%w: %s", ErrWebhookBlocked, host)
+		}
+	}
+	for _, t := range req.Events {
+		if !slices.Contains(webhookEventTypes, t) {
+			return Webhook{}, fmt.Errorf("%w: unknown event type %s", ErrWebhookInvalid, t)
+		}
+	}
+	h := &Webhook{
+		ID:         randomID(8),
+		URL:        req.URL,
+		Secret:     req.Secret,
+		Events:     req.Events,
+		Board:      req.Board,
+		TopN:       req.TopN,
+		Milestones: slices.Sorted(slices.Values(req.Milestones)),
+		Docs:       req.Docs,
+		Categories: req.Categories,
+		CreatedAt:  time.Now(),
+	}
+	if h.Board == "" {
+		h.Board = "total"
+	}
+	if !slices.Contains(rankBoardNames, h.Board) {
+		return Webhook{}, ErrUnknownBoard
+	}
+	if h.TopN == 0 {
+		h.TopN = 10
+	}
+	if h.TopN < 0 || h.TopN > w.maxTopN {
+		return Webhook{}, fmt.Errorf("%w: top_n must be between 1 and %d", ErrWebhookInvalid, w.maxTopN)
+	}
+	if len(h.Milestones) > 0 && h.Milestones[0] <= 0 {
+		return Webhook{}, fmt.Errorf("%w: milestones must be positive", ErrWebhookInvalid)
+	}
+	if h.Secret == "" {
+		b := make([]byte, 24)
+		_, _ = rand.Read(b)
+		h.Secret = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.maxHooks > 0 && len(w.hooks) >= w.maxHooks {
+		return Webhook{}, ErrWebhookLimit
+	}
+	w.hooks = append(w.hooks, h)
+	w.dirty = true
+	return *h, nil
+}
+
+// List 返回全部回调，不含签名密钥
+func (w *Webhooks) List() []Webhook {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]Webhook, len(w.hooks))
+	for i, h := range w.hooks {
+		out[i] = *h
+		out[i].Secret = ""
+	}
+	return out
+}
+
+func (w *Webhooks) findLocked(id string) int {
+	return slices.IndexFunc(w.hooks, func(h *Webhook) bool { return h.ID == id })
+}
+
+// Delete 删除回调及其待投递的事件
+func (w *Webhooks) Delete(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	i := w.findLocked(id)
+	if i < 0 {
+		return ErrWebhookNotFound
+	}
+	w.hooks = slices.Delete(w.hooks, i, i+1)
+	w.pending = slices.DeleteFunc(w.pending, func(d *webhookDelivery) bool { return d.HookID == id && !d.inflight })
+	w.dirty = true
+	return nil
+}
+
+// Test 向回调发送一个 ping 事件，返回投递 ID
+func (w *Webhooks) Test(id string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	i := w.findLocked(id)
+	if i < 0 {
+		return "", ErrWebhookNotFound
+	}
+	ev := WebhookEvent{Type: hookPing}
+	return w.enqueueLocked(w.hooks[i], &ev, time.Now()), nil
+}
+
+// Deliveries 返回回调的待投递事件与最近的投递记录 (新的在前)
+func (w *Webhooks) Deliveries(id string, limit int) ([]webhookDelivery, []WebhookLogEntry, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.findLocked(id) < 0 {
+		return nil, nil, ErrWebhookNotFound
+	}
+	pending := []webhookDelivery{}
+	for _, d := range w.pending {
+		if d.HookID == id {
+			pending = append(pending, *d)
+		}
+	}
+	entries := []WebhookLogEntry{}
+	for i := len(w.log) - 1; i >= 0 && len(entries) < limit; i-- {
+		if w.log[i].HookID == id {
+			entries = append(entries, w.log[i])
+		}
+	}
+	return pending, entries, nil
+}
+
+// emitLocked 为订阅该事件的回调排队投递
+func (w *Webhooks) emitLocked(ev WebhookEvent, now time.Time) {
+	for _, h := range w.hooks {
+		if h.wants(&ev) {
+			w.enqueueLocked(h, &ev, now)
+		}
+	}
+}
+
+// enqueueLocked 排队一次投递；队列已满时丢弃并记录
+func (w *Webhooks) enqueueLocked(h *Webhook, ev *WebhookEvent, now time.Time) string {
+	if ev.ID == "" {
+		ev.ID = randomID(8)
+		ev.Namespace = w.ns
+		ev.Time = now
+	}
+	d := &webhookDelivery{ID: randomID(8), HookID: h.ID, Event: *ev, NextAt: now}
+	if w.queueMax > 0 && len(w.pending) >= w.queueMax {
+		w.appendLogLocked(WebhookLogEntry{DeliveryID: d.ID, HookID: h.ID, EventID: ev.ID, Type: ev.Type, Time: now, Result: deliveryDropped})
+		return d.ID
+	}
+	w.pending = append(w.pending, d)
+	w.dirty = true
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return d.ID
+}
+
+func (w *Webhooks) appendLogLocked(e WebhookLogEntry) {
+	w.log = append(w.log, e)
+	if len(w.log) > w.logSize {
+		w.log = slices.Delete(w.log, 0, len(w.log)-w.logSize)
+	}
+	w.dirty = true
+}
+
+// boards 比较前后两版榜单，为进入或跌出前 N 的文档触发事件；由 Store 在推送榜单时调用
+func (w *Webhooks) boards(prev, cur map[string][]BoardEntry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.hooks) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, name := range rankBoardNames {
+		before, after := prev[name], cur[name]
+		for _, h := range w.hooks {
+			if h.Board != name {
+				continue
+			}
+			top := func(entries []BoardEntry) []BoardEntry { return entries[:min(h.TopN, len(entries))] }
+			inTop := func(entries []BoardEntry, id string) bool {
+				return slices.ContainsFunc(top(entries), func(e BoardEntry) bool { return e.DocID == id })
+			}
+			for _, e := range top(after) {
+				if !inTop(before, e.DocID) {
+					ev := WebhookEvent{Type: hookTopEnter, DocID: e.DocID, Title: e.Title, URL: e.URL, Category: e.Category,
+						Board: name, Rank: e.Rank, TopN: h.TopN, Clicks: e.Clicks}
+					if h.wants(&ev) {
+						w.enqueueLocked(h, &ev, now)
+					}
+				}
+			}
+			for _, e := range top(before) {
+				if !inTop(after, e.DocID) {
+					ev := WebhookEvent{Type: hookTopLeave, DocID: e.DocID, Title: e.Title, URL: e.URL, Category: e.Category,
+						Board: name, TopN: h.TopN}
+					if h.wants(&ev) {
+						w.enqueueLocked(h, &ev, now)
+					}
+				}
+			}
+		}
+	}
+}
+
+// clicked 在文档总点击数跨过里程碑时触发事件；由 Store 在计入点击后调用
+func (w *Webhooks) clicked(doc Doc, prev, cur int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, h := range w.hooks {
+		for _, m := range h.Milestones {
+			if prev < m && m <= cur {
+				ev := WebhookEvent{Type: hookMilestone, DocID: doc.ID, Title: doc.Title, URL: doc.URL, Category: doc.Meta[metaCategory],
+					Clicks: cur, Milestone: m}
+				if h.wants(&ev) {
+					w.enqueueLocked(h, &ev, time.Now())
+				}
+			}
+		}
+	}
+}
+
+// deleted 在文档被删除时触发事件
+func (w *Webhooks) deleted(doc Doc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ev := WebhookEvent{Type: hookDocDelete, DocID: doc.ID, Title: doc.Title, URL: doc.URL, Category: doc.Meta[metaCategory]}
+	w.emitLocked(ev, time.Now())
+}
+
+// sign 计算签名：HMAC-SHA256(secret, 时间戳 + "." + 请求体)
+func (w *Webhooks) sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Start 启动投递与定期落盘，stop 关闭后循环退出
+func (w *Webhooks) Start(stop <-chan struct{}) {
+	w.loop.Add(1)
+	go func() {
+		defer w.loop.Done()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				w.dispatch(now)
+				if err := w.saveIfDirty(); err != nil {
+					log.Printf("[%s] webhooks save error: %v", w.ns, err)
+				}
+			case <-w.wake:
+				w.dispatch(time.Now())
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// dispatch 投递已到期的事件，同一回调的事件按顺序逐个投递；Close 开始后不再发起投递
+func (w *Webhooks) dispatch(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ctx.Err() != nil {
+		return
+	}
+	busy := make(map[string]bool)
+	for _, d := range w.pending {
+		if d.inflight {
+			busy[d.HookID] = true
+		}
+	}
+	for _, d := range w.pending {
+		if d.inflight || busy[d.HookID] || d.NextAt.After(now) {
+			// 同一回调的后续事件等待前一个完成，保持顺序
+			busy[d.HookID] = true
+			continue
+		}
+		i := w.findLocked(d.HookID)
+		if i < 0 {
+			continue
+		}
+		select {
+		case w.sem <- struct{}{}:
+		default:
+			return
+		}
+		d.inflight = true
+		busy[d.HookID] = true
+		w.wg.Add(1)
+		go w.deliver(*w.hooks[i], d)
+	}
+}
+
+// deliver 发送一次回调并记录结果
+func (w *Webhooks) deliver(h Webhook, d *webhookDelivery) {
+	defer w.wg.Done()
+	defer func() { <-w.sem }()
+
+	body, _ := json.Marshal(d.Event)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	start := time.Now()
+	var status int
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "doc-rank-webhook")
+		req.Header.Set("X-DocRank-Event", d.Event.Type)
+		req.Header.Set("X-DocRank-Delivery", d.ID)
+		req.Header.Set("X-DocRank-Timestamp", ts)
+		req.Header.Set("X-DocRank-Signature", w.sign(h.Secret, ts, body))
+		var resp *http.Response
+		if resp, err = w.client.Do(req); err == nil {
+			status = resp.StatusCode
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			if status < 200 || status > 299 {
+				err = fmt.Errorf("unexpected status %d", status)
+			}
+		}
+	}
+	w.finish(d, status, err, start)
+}
+
+// finish 记录投递结果：成功或重试用尽时移出队列，否则按指数退避安排重试
+func (w *Webhooks) finish(d *webhookDelivery, status int, err error, start time.Time) {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d.inflight = false
+	d.Attempts++
+	e := WebhookLogEntry{
+		DeliveryID: d.ID,
+		HookID:     d.HookID,
+		EventID:    d.Event.ID,
+		Type:       d.Event.Type,
+		Attempt:    d.Attempts,
+		Time:       start,
+		Status:     status,
+		DurationMs: now.Sub(start).Milliseconds(),
+		Result:     deliveryOK,
+	}
+	if err != nil {
+		e.Error = err.Error()
+		if d.Attempts >= w.maxAttempts {
+			e.Result = deliveryFailed
+		} else {
+			e.Result = deliveryRetry
+			d.NextAt = now.Add(w.retryDelay(d.Attempts))
+			e.NextAt = d.NextAt
+		}
+	}
+	// 回调在投递期间被删除时不再重试
+	if e.Result != deliveryRetry || w.findLocked(d.HookID) < 0 {
+		w.pending = slices.DeleteFunc(w.pending, func(p *webhookDelivery) bool { return p == d })
+	}
+	w.appendLogLocked(e)
+	// 继续投递同一回调的后续事件
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retryDelay 返回第 attempts 次失败后的重试间隔：逐次翻倍，不超过 backoffMax
+// 达到上限即停止翻倍，重试次数很大时也不会溢出
+func (w *Webhooks) retryDelay(attempts int) time.Duration {
+	d := w.backoff
+	for i := 1; i < attempts && d < w.backoffMax; i++ {
+		d *= 2
+	}
+	return min(d, w.backoffMax)
+}
+
+// saveIfDirty 有变化时落盘
+func (w *Webhooks) saveIfDirty() error {
+	w.mu.Lock()
+	if !w.dirty {
+		w.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(webhooksFile{Hooks: w.hooks, Pending: w.pending, Log: w.log})
+	w.dirty = false
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(w.path, b)
+}
+
+// Close 中止进行中的投递 (计为失败并安排重试) 并落盘
+// 调用前需先关闭传给 Start 的 stop；等调度循环退出后才等待投递，避免新投递在等待期间加入
+func (w *Webhooks) Close() error {
+	w.loop.Wait()
+	w.mu.Lock()
+	w.cancel()
+	w.mu.Unlock()
+	w.wg.Wait()
+	return w.saveIfDirty()
+}
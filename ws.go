@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket 应答事件名
+const (
+	eventClick = "click" // 点击结果，data 同 POST /click 的响应
+	eventError = "error" // 请求失败，data 为 {code, message}
+)
+
+// wsWriteWait 为单次写入的超时
+const wsWriteWait = 10 * time.Second
+
+// WSServer 提供 WebSocket 连接：与 SSE 共用 SSEHub 下发相同的事件，并在同一连接上接收点击
+type WSServer struct {
+	upgrader websocket.Upgrader
+	auth     *Auth
+	tokens   *ClickTokens
+	limiter  *RateLimiter
+	ping     time.Duration
+	buffer   int
+	maxMsg   int64
+}
+
+// NewWSServer 创建 WSServer
+func NewWSServer(auth *Auth, tokens *ClickTokens, limiter *RateLimiter, cfg Config) *WSServer {
+	ws := &WSServer{
+		auth:    auth,
+		tokens:  tokens,
+		limiter: limiter,
+		ping:    cfg.WSPingInterval,
+		buffer:  cfg.WSSendBuffer,
+		maxMsg:  int64(cfg.WSMaxMessage),
+	}
+	ws.upgrader.CheckOrigin = checkOrigin(cfg.WSAllowedOrigins)
+	// 握手失败时与其他接口一致返回 JSON 错误
+	ws.upgrader.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(gin.H{"code": status, "message": reason.Error()})
+	}
+	return ws
+}
+
+// checkOrigin 返回 Origin 校验函数；未配置时只允许同源，不带 Origin 的非浏览器客户端总是允许
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || slices.Contains(allowed, "*") || slices.Contains(allowed, origin) {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && len(allowed) == 0 && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// wsEncode 编码应答消息
+func wsEncode(event string, v any) WSMessage {
+	b, _ := json.Marshal(v)
+	return WSMessage{Event: event, Data: b}
+}
+
+// Serve 升级为 WebSocket 连接
+// 订阅参数与断线续传 (last_event_id) 同 /events；客户端发送 {"type":"click",...} 上报点击
+func (ws *WSServer) Serve(c *gin.Context) {
+	filter, err := parseSSEFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	conn, err := ws.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// 错误响应已由 upgrader.Error 写出
+		return
+	}
+	defer conn.Close()
+
+	hub := nsOf(c).sse
+	cl, initial := hub.subscribe(c, filter, ws.buffer, "ws")
+	defer hub.unsubscribe(cl)
+
+	replies := make(chan WSMessage, ws.buffer)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go ws.readLoop(c, conn, replies, quit, done)
+
+	ws.writeLoop(conn, hub, cl, initial, replies, done)
+	// 写入结束后关闭连接使读取返回；需等读取结束，gin.Context 在处理函数返回后会被复用
+	close(quit)
+	conn.Close()
+	<-done
+}
+
+// writeLoop 为连接唯一的写入者，依次写出事件、应答与心跳，直到读取结束或写入失败
+func (ws *WSServer) writeLoop(conn *websocket.Conn, hub *SSEHub, cl *sseClient, initial []sseMessage, replies <-chan WSMessage, done <-chan struct{}) {
+	ticker := time.NewTicker(ws.ping)
+	defer ticker.Stop()
+
+	write := func(m WSMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(m) == nil
+	}
+	writeEvents := func(msgs ...sseMessage) bool {
+		for _, msg := range msgs {
+			if !write(WSMessage{ID: hub.eventID(msg.id), Event: msg.event, Data: msg.data}) {
+				return false
+			}
+			cl.advance(msg.id)
+		}
+		return true
+	}
+	if !writeEvents(initial...) {
+		return
+	}
+	for {
+		select {
+		case msg := <-cl.ch:
+			if cl.lagged.Load() {
+				if !writeEvents(hub.resync(cl)...) {
+					return
+				}
+			} else if !writeEvents(msg) {
+				return
+			}
+		case <-cl.done:
+			// 因慢消费被断开时客户端可带 last_event_id 重连；命名空间关闭则不应重连
+			code := websocket.CloseTryAgainLater
+			if cl.closeReason == closeNamespace {
+				code = websocket.CloseGoingAway
+			}
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(code, cl.closeReason), time.Now().Add(wsWriteWait))
+			return
+		case m := <-replies:
+			if !write(m) {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// readLoop 读取客户端消息并处理；超过两倍心跳间隔未收到任何消息或 pong 即断开
+func (ws *WSServer) readLoop(c *gin.Context, conn *websocket.Conn, replies chan<- WSMessage, quit <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	conn.SetReadLimit(ws.maxMsg)
+	extend := func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * ws.ping))
+	}
+	extend("")
+	conn.SetPongHandler(extend)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		extend("")
+		select {
+		case replies <- ws.handle(c, data):
+		case <-quit:
+			return
+		}
+	}
+}
+
+// handle 处理一条客户端消息并返回应答
+func (ws *WSServer) handle(c *gin.Context, data []byte) WSMessage {
+	var req WSRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return wsEncode(eventError, gin.H{"code": 400, "message": "bad request"})
+	}
+	fail := func(code int, message string) WSMessage {
+		return wsEncode(eventError, gin.H{"req_id": req.ReqID, "code": code, "message": message})
+	}
+	switch req.Type {
+	case "click":
+	default:
+		return fail(http.StatusBadRequest, "unknown message type")
+	}
+	if req.DocID == "" {
+		return fail(http.StatusBadRequest, "bad request")
+	}
+	// 连接只要求读取权限，点击另需 clicker 角色
+	if !ws.auth.hasRole(c, roleClicker) {
+		return fail(http.StatusForbidden, "requires role "+roleClicker)
+	}
+	action, wait := ws.limiter.Check("click", c)
+	if action == limitReject {
+		return wsEncode(eventError, gin.H{"req_id": req.ReqID, "code": 429, "message": "rate limit exceeded", "retry_after": retryAfter(wait)})
+	}
+	status, body := submitClick(c, ws.tokens, req.ClickReq, action == limitUncounted)
+	body["req_id"] = req.ReqID
+	if status != http.StatusOK {
+		return wsEncode(eventError, body)
+	}
+	return wsEncode(eventClick, body)
+}